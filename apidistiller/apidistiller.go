@@ -1,9 +1,17 @@
 package apidistiller
 
 import (
+    "encoding/json"
     "fmt"
     "regexp"
+    "strconv"
+    "strings"
+    "time"
     "go/ast"
+    "go/token"
+    "go/types"
+    "unicode"
+    "unicode/utf8"
 )
 
 // BasicTypes maps basic go types to the corresponding vesupro tokens.
@@ -32,24 +40,243 @@ var BasicTypes = map[string][]string{
 // belong to the Api.
 var VesuproRegexp = regexp.MustCompile("^//\\s*vesupro:\\s*export.*$")
 
+// CostRegexp extracts the cost of a method from its export directive, e.g.
+// `// vesupro: export cost=10`.
+var CostRegexp = regexp.MustCompile("\\bcost\\s*=\\s*([0-9]+)")
+
+// CacheableRegexp marks methods whose results may be cached, optionally
+// with a time to live, e.g. `// vesupro: export cacheable ttl=30s`.
+var CacheableRegexp = regexp.MustCompile("\\bcacheable\\b")
+
+// TTLRegexp extracts the time to live of cacheable methods.
+var TTLRegexp = regexp.MustCompile("\\bttl\\s*=\\s*([0-9a-z.]+)")
+
+// DefaultRegexp finds default values of optional parameters, e.g.
+// `// vesupro: export default limit=10 default opts={"deep": true}`. It
+// matches up to the value, which is a vesupro argument literal: a number, a
+// quoted string, true, false or a JSON object or array.
+var DefaultRegexp = regexp.MustCompile("\\bdefault\\s+(\\w+)\\s*=\\s*")
+
 // # API Representation #
 
 // Parameter represents a formal parameter of an exported method.
 type Parameter struct {
     Position uint   // position of the argument
-    TypeName string // name of the type 'A' for '*A' for instance
+    Name string     // name of the parameter, empty if unnamed
+    // TypeName is the name of the type, 'A' for '*A' for instance. Types of
+    // other packages are qualified by their import path, e.g.
+    // "github.com/google/uuid.UUID".
+    TypeName string
+    // Default is the literal used if the argument is omitted. It is empty
+    // for required parameters.
+    Default string
 
-    // Currently, only two type of parameters are supported:
+    // Currently, only three type of parameters are supported:
     // 1. basic non-pointers types (int, uint, ...)
     // 2. pointer to struct types
+    // 3. text types, see API.TextTypes
     // if IsStruct is true, the type is *A, where A is a struct type
     IsStruct bool
+    // if IsText is true, the type implements encoding.TextUnmarshaler and
+    // is passed as string, e.g. time.Time in RFC 3339 format
+    IsText bool
+
+    // Either type may be passed as slice []T or, for the last parameter, as
+    // variadic ...T. Slices are passed as JSON array, variadic parameters as
+    // any number of trailing arguments.
+    IsSlice bool
+    IsVariadic bool
+    // IsMap is set for map[string]T, which is passed as JSON object.
+    IsMap bool
+}
+
+// parseType sets the type of p from the parameter type expr of file f.
+// textTypes are the known text types, see API.TextTypes. Errors are
+// completed by the caller.
+func (p *Parameter) parseType(f *ast.File, expr ast.Expr,
+textTypes map[string]bool) error {
+    switch t := expr.(type) {
+    case (*ast.Ellipsis):
+        p.IsVariadic = true
+        expr = t.Elt
+    case (*ast.ArrayType):
+        if t.Len != nil {
+            return fmt.Errorf("Arrays are not supported, use a slice")
+        }
+        p.IsSlice = true
+        expr = t.Elt
+    }
+    if t, ok := expr.(*ast.MapType); ok {
+        if key, ok := t.Key.(*ast.Ident); !ok || key.Name != "string" {
+            return fmt.Errorf("Map keys must be strings, not %s",
+                types.ExprString(t.Key))
+        }
+        p.IsMap = true
+        expr = t.Value
+    }
+
+    switch t := expr.(type) {
+    case (*ast.Ident):
+        p.TypeName = t.Name
+        // check whether it's a basic type
+        _, found := BasicTypes[t.Name]
+        if textTypes[t.Name] && !found {
+            p.IsText = true
+        } else if !found {
+            return fmt.Errorf("Unsupported Type %s", t.Name)
+        }
+        return nil
+    case (*ast.SelectorExpr):
+        // qualified types are only supported as text types
+        p.TypeName = types.ExprString(t)
+        if !textTypes[p.TypeName] {
+            return fmt.Errorf("Unsupported Type %s", p.TypeName)
+        }
+        // named by import path, so that generated code can import it
+        if name, ok := qualifiedTypeName(f, t); ok {
+            p.TypeName = name
+        }
+        p.IsText = true
+        return nil
+    case (*ast.StarExpr):
+        if p.IsMap { break }
+        // we just assume that this is a struct
+        ident, ok := t.X.(*ast.Ident)
+        if !ok { break }
+        _, found := BasicTypes[ident.Name]
+        if found {
+            return fmt.Errorf(
+                "Pointers to basic types such as *%s are not supported",
+                ident.Name)
+        }
+        p.TypeName = ident.Name
+        p.IsStruct = true
+        return nil
+    }
+    return fmt.Errorf("Unsupported type expression %s",
+        types.ExprString(expr))
+}
+
+// parseDefault sets the default of p to the literal at the start of value
+// and returns the rest of value. Argument literals are valid JSON values, so
+// the literal is delimited by a JSON decoder; the distiller cannot use the
+// tokenizer of package vesupro, which imports it. Errors are completed by
+// the caller.
+func (p *Parameter) parseDefault(value string) (string, error) {
+    if p.IsVariadic {
+        return "", fmt.Errorf("Variadic parameters cannot have a default")
+    }
+    dec := json.NewDecoder(strings.NewReader(value))
+    var v interface{}
+    if err := dec.Decode(&v); err != nil {
+        return "", fmt.Errorf("Invalid default")
+    }
+    literal := strings.TrimSpace(value[:dec.InputOffset()])
+
+    // the kind of literal the parameter expects, see BasicTypes
+    var valid bool
+    switch {
+    case p.IsSlice:
+        valid = literal[0] == '['
+    case p.IsMap || p.IsStruct:
+        valid = literal[0] == '{'
+    case p.IsText:
+        valid = literal[0] == '"'
+    default:
+        switch BasicTypes[p.TypeName][0] {
+        case "vesupro.INT":
+            unsigned := strings.HasPrefix(p.TypeName, "uint") ||
+                p.TypeName == "byte"
+            valid = isNumber(literal) &&
+                !strings.ContainsAny(literal, ".eE") &&
+                !(unsigned && literal[0] == '-')
+        case "vesupro.FLOAT":
+            valid = isNumber(literal) && strings.ContainsAny(literal, ".eE")
+        case "vesupro.TRUE":
+            valid = literal == "true" || literal == "false"
+        case "vesupro.STRING":
+            valid = literal[0] == '"'
+        }
+    }
+    if !valid {
+        return "", fmt.Errorf("Default %s does not match type %s", literal,
+            p.GoType())
+    }
+    p.Default = literal
+    return value[dec.InputOffset():], nil
+}
+
+// isNumber reports whether literal is a JSON number.
+func isNumber(literal string) bool {
+    return literal[0] == '-' || '0' <= literal[0] && literal[0] <= '9'
+}
+
+// DisplayName returns the name of the parameter for messages, e.g. "limit"
+// or "at position 1" for unnamed parameters.
+func (p *Parameter) DisplayName() string {
+    if p.Name != "" { return p.Name }
+    return fmt.Sprintf("at position %d", p.Position)
+}
+
+// GoType returns the go type of the parameter, e.g. "[]*A" or "...int".
+func (p *Parameter) GoType() string {
+    typeName := p.TypeName
+    if p.IsStruct {
+        typeName = "*" + typeName
+    }
+    if p.IsMap {
+        typeName = "map[string]" + typeName
+    }
+    switch {
+    case p.IsVariadic:
+        return "..." + typeName
+    case p.IsSlice:
+        return "[]" + typeName
+    }
+    return typeName
 }
 
 // Method represents an exported method of the API.
 type Method struct {
     Name string
     Params []*Parameter
+    // Doc is the doc comment of the method without the export directive.
+    Doc string
+    Cost uint // cost declared in the export directive, 0 if none
+
+    // TakesContext is set if the method takes a leading context.Context,
+    // which is not part of Params but injected by the dispatcher.
+    TakesContext bool
+    // ReturnsError is set if the last result of the method is an error.
+    ReturnsError bool
+    // Result is the type name of the first result other than an error,
+    // qualified like embedded types, e.g. "User" for *User. It is empty if
+    // there is none or the result is no named type, e.g. a slice.
+    Result string
+
+    // Cacheable is set if the method is idempotent and its results may be
+    // cached for CacheTTL, or the cache's default if CacheTTL is zero.
+    Cacheable bool
+    CacheTTL time.Duration
+
+    // Promoted is the name of the embedded type the method is promoted
+    // from, empty for methods declared on the receiver itself.
+    Promoted string
+}
+
+// WireName returns the name under which the method is called in programs,
+// i.e., the method name starting with a lower case letter.
+func (m *Method) WireName() string {
+    r, size := utf8.DecodeRuneInString(m.Name)
+    return string(unicode.ToLower(r)) + m.Name[size:]
+}
+
+// Param returns the parameter called name, nil if there is none.
+func (m *Method) Param(name string) *Parameter {
+    for _, param := range m.Params {
+        if param.Name == name { return param }
+    }
+    return nil
 }
 
 // API represents the api
@@ -57,21 +284,288 @@ type API struct {
     // maps receiver type to functions
     Methods map[string] []*Method
     PackageName string
+    // maps type names to the doc comments of their declarations
+    TypeDocs map[string]string
+    // TextTypes are the types implementing encoding.TextUnmarshaler which
+    // may be used as parameters. Types of the package declaring an
+    // UnmarshalText method are added while distilling, so the method must
+    // be declared in the same or an earlier distilled file, or in any file
+    // passed to DistillFromAstFiles. Types of other packages have to be
+    // added before distilling as they are written in the source, e.g.
+    // "uuid.UUID"; parameters name them by import path, see Parameter.
+    TextTypes map[string]bool
+    // maps struct types to the types they embed, whose methods are
+    // promoted to them
+    Embeds map[string][]string
+    // maps import paths to package names for APIs holding the qualified
+    // receivers of several packages, see Qualify and Merge. It is nil for
+    // the API of a single package.
+    Packages map[string]string
 }
 
 // NewAPI Creates a new Api.
 func NewAPI(pkgName string) *API {
-    return &API{make(map[string][]*Method, 0), pkgName}
+    return &API{Methods: make(map[string][]*Method, 0),
+        PackageName: pkgName, TypeDocs: make(map[string]string),
+        TextTypes: map[string]bool{"time.Time": true},
+        Embeds: make(map[string][]string)}
+}
+
+// Method returns the method of typeName called wireName in programs, nil if
+// there is none.
+func (api *API) Method(typeName string, wireName string) *Method {
+    for _, method := range api.Methods[typeName] {
+        if method.WireName() == wireName { return method }
+    }
+    return nil
+}
+
+// docText returns the text of doc without export directives.
+func docText(doc *ast.CommentGroup) string {
+    if doc == nil { return "" }
+    text := &ast.CommentGroup{}
+    for _, comment := range doc.List {
+        if !VesuproRegexp.MatchString(comment.Text) {
+            text.List = append(text.List, comment)
+        }
+    }
+    return text.Text()
+}
+
+// distillTypeDocs records the doc comments of the type declarations in f.
+func (api *API) distillTypeDocs(f *ast.File) {
+    for _, decl := range f.Decls {
+        gDecl, ok := decl.(*ast.GenDecl)
+        if !ok || gDecl.Tok != token.TYPE { continue }
+        for _, spec := range gDecl.Specs {
+            tSpec := spec.(*ast.TypeSpec)
+            doc := tSpec.Doc
+            // the comment of an unparenthesized declaration belongs to it
+            if doc == nil && !gDecl.Lparen.IsValid() {
+                doc = gDecl.Doc
+            }
+            if text := docText(doc); text != "" {
+                api.TypeDocs[tSpec.Name.Name] = text
+            }
+        }
+    }
+}
+
+// baseTypeName returns the name of the type expr without pointer and type
+// arguments, e.g. "List" for `*List[T]`, and false if expr is not of this
+// form.
+func baseTypeName(expr ast.Expr) (string, bool) {
+    if star, ok := expr.(*ast.StarExpr); ok {
+        expr = star.X
+    }
+    switch t := expr.(type) {
+    case (*ast.IndexExpr):
+        expr = t.X
+    case (*ast.IndexListExpr):
+        expr = t.X
+    }
+    ident, ok := expr.(*ast.Ident)
+    if !ok { return "", false }
+    return ident.Name, true
+}
+
+// embeddedTypeName returns the name of the embedded type expr. Types of
+// other packages are qualified by their import path, e.g.
+// "example.com/base.Entity" for `base.Entity`, so that their methods are
+// promoted once the API of their package is merged, see Merge.
+func embeddedTypeName(f *ast.File, expr ast.Expr) (string, bool) {
+    if star, ok := expr.(*ast.StarExpr); ok {
+        expr = star.X
+    }
+    sel, ok := expr.(*ast.SelectorExpr)
+    if !ok { return baseTypeName(expr) }
+    return qualifiedTypeName(f, sel)
+}
+
+// qualifiedTypeName returns the name of the type sel of another package
+// qualified by the import path of the package in f, e.g.
+// "github.com/google/uuid.UUID" for `uuid.UUID`.
+func qualifiedTypeName(f *ast.File, sel *ast.SelectorExpr) (string, bool) {
+    pkg, ok := sel.X.(*ast.Ident)
+    if !ok { return "", false }
+    for _, imp := range f.Imports {
+        path, err := strconv.Unquote(imp.Path.Value)
+        if err != nil { continue }
+        name := pathName(path)
+        if imp.Name != nil {
+            name = imp.Name.Name
+        }
+        if name == pkg.Name { return path + "." + sel.Sel.Name, true }
+    }
+    return "", false
+}
+
+// distillEmbeds records the types embedded in the struct types of f.
+func (api *API) distillEmbeds(f *ast.File) {
+    for _, decl := range f.Decls {
+        gDecl, ok := decl.(*ast.GenDecl)
+        if !ok || gDecl.Tok != token.TYPE { continue }
+        for _, spec := range gDecl.Specs {
+            tSpec := spec.(*ast.TypeSpec)
+            sType, ok := tSpec.Type.(*ast.StructType)
+            if !ok { continue }
+            for _, field := range sType.Fields.List {
+                if len(field.Names) > 0 { continue }
+                embed, ok := embeddedTypeName(f, field.Type)
+                if !ok || contains(api.Embeds[tSpec.Name.Name], embed) {
+                    continue
+                }
+                api.Embeds[tSpec.Name.Name] = append(
+                    api.Embeds[tSpec.Name.Name], embed)
+            }
+        }
+    }
+}
+
+func contains(names []string, name string) bool {
+    for _, n := range names {
+        if n == name { return true }
+    }
+    return false
+}
+
+// promote recomputes the methods promoted from embedded types. As in go, a
+// method is promoted from the shallowest depth only, and not at all if
+// several embedded types provide it at that depth. Declared methods shadow
+// promoted ones.
+func (api *API) promote() {
+    declared := make(map[string][]*Method)
+    for typeName, methods := range api.Methods {
+        for _, method := range methods {
+            if method.Promoted == "" {
+                declared[typeName] = append(declared[typeName], method)
+            }
+        }
+    }
+
+    depth := make(map[*Method]int) // depth of promoted methods
+    sets := make(map[string][]*Method)
+    visiting := make(map[string]bool)
+    var methodSet func(typeName string) []*Method
+    methodSet = func(typeName string) []*Method {
+        if set, done := sets[typeName]; done { return set }
+        // embedding cycles are invalid go, stop at them
+        if visiting[typeName] { return declared[typeName] }
+        visiting[typeName] = true
+
+        set := append([]*Method(nil), declared[typeName]...)
+        shallowest := make(map[string]int)
+        count := make(map[string]int)
+        var candidates []*Method
+        for _, m := range set {
+            shallowest[m.WireName()] = 0
+            count[m.WireName()] = 1
+        }
+        for _, embed := range api.Embeds[typeName] {
+            for _, m := range methodSet(embed) {
+                promoted := *m
+                promoted.Promoted = embed
+                depth[&promoted] = depth[m] + 1
+                name := m.WireName()
+                d, found := shallowest[name]
+                if found && d < depth[&promoted] { continue }
+                if found && d == depth[&promoted] {
+                    count[name]++
+                } else {
+                    shallowest[name], count[name] = depth[&promoted], 1
+                }
+                candidates = append(candidates, &promoted)
+            }
+        }
+        for _, m := range candidates {
+            name := m.WireName()
+            if shallowest[name] == depth[m] && count[name] == 1 {
+                set = append(set, m)
+            }
+        }
+        sets[typeName] = set
+        return set
+    }
+
+    for typeName := range declared {
+        api.Methods[typeName] = methodSet(typeName)
+    }
+    for typeName := range api.Embeds {
+        if set := methodSet(typeName); len(set) > 0 {
+            api.Methods[typeName] = set
+        }
+    }
+}
+
+// distillTextTypes records the types of f declaring an UnmarshalText
+// method.
+func (api *API) distillTextTypes(f *ast.File) {
+    for _, decl := range f.Decls {
+        fDecl, ok := decl.(*ast.FuncDecl)
+        if !ok || fDecl.Recv == nil || fDecl.Name.Name != "UnmarshalText" {
+            continue
+        }
+        if len(fDecl.Type.Params.List) != 1 { continue }
+        if star, ok := fDecl.Recv.List[0].Type.(*ast.StarExpr); ok {
+            if ident, ok := star.X.(*ast.Ident); ok {
+                api.TextTypes[ident.Name] = true
+            }
+        }
+    }
+}
+
+// isContext reports whether expr is the type context.Context.
+func isContext(expr ast.Expr) bool {
+    return types.ExprString(expr) == "context.Context"
+}
+
+// returnsError reports whether the last of results is an error.
+func returnsError(results *ast.FieldList) bool {
+    if results == nil || len(results.List) == 0 { return false }
+    ident, ok := results.List[len(results.List)-1].Type.(*ast.Ident)
+    return ok && ident.Name == "error"
+}
+
+// resultTypeName returns the name of the first result type other than
+// error, see embeddedTypeName, or "" if there is none.
+func resultTypeName(f *ast.File, results *ast.FieldList) string {
+    if results == nil || len(results.List) == 0 { return "" }
+    if len(results.List) == 1 && len(results.List[0].Names) < 2 &&
+    returnsError(results) {
+        return ""
+    }
+    name, _ := embeddedTypeName(f, results.List[0].Type)
+    return name
+}
+
+// DistillFromAstFiles distills the API from files, the files of a package.
+// The text types of all files are recorded first, so the result does not
+// depend on the order of files.
+func (api *API) DistillFromAstFiles(files []*ast.File) error {
+    for _, f := range files {
+        if f.Name.Name == api.PackageName {
+            api.distillTextTypes(f)
+        }
+    }
+    for _, f := range files {
+        if err := api.DistillFromAstFile(f); err != nil { return err }
+    }
+    return nil
 }
 
 // DistillFromAstFile distills the API from the provided ast.file
 func (api *API) DistillFromAstFile(f *ast.File) error {
     if f.Name.Name != api.PackageName {
         return fmt.Errorf("Including methods from different packages in the " +
-            "same API is not supported (api package %q, file package %q).",
+            "same API is not supported (api package %q, file package %q), "+
+            "distill them separately and merge them.",
             api.PackageName, f.Name.Name)
     }
 
+    api.distillTypeDocs(f)
+    api.distillTextTypes(f)
+    api.distillEmbeds(f)
+
     // iterate through declarations
     for _, decl := range f.Decls {
         fDecl, ok := decl.(*ast.FuncDecl)
@@ -80,78 +574,104 @@ func (api *API) DistillFromAstFile(f *ast.File) error {
 
         // check whether function is supposed to be exported
         match := false
+        var directive string
         if fDecl.Doc != nil {
             for _, comment := range fDecl.Doc.List {
                 match = VesuproRegexp.MatchString(comment.Text)
-                if match { break }
+                if match {
+                    directive = comment.Text
+                    break
+                }
             }
         }
         if !match { continue }
 
-        var receiverTypeName string
+        // receivers are of the form T or *T, generic ones T[P] or *T[P]
+        recvType := fDecl.Recv.List[0].Type
+        receiverTypeName, ok := baseTypeName(recvType)
+        if !ok {
+            return fmt.Errorf("Unsupported receiver type %s of method %s.",
+                types.ExprString(recvType), fDecl.Name.Name)
+        }
 
-        switch t := fDecl.Recv.List[0].Type.(type) {
-        case (*ast.StarExpr):
-            if ident, ok := t.X.(*ast.Ident); ok {
-                receiverTypeName = ident.Name
+        // parse methods
+        methodCall := &Method{Name: fDecl.Name.Name,
+            Doc: docText(fDecl.Doc)}
+        if m := api.Method(receiverTypeName, methodCall.WireName());
+        m != nil && m.Promoted == "" {
+            return fmt.Errorf("Method %s of %s is declared twice.",
+                fDecl.Name.Name, receiverTypeName)
+        }
+        if m := CostRegexp.FindStringSubmatch(directive); m != nil {
+            cost, err := strconv.ParseUint(m[1], 10, 32)
+            if err != nil {
+                return fmt.Errorf("Invalid cost %q of method %s.", m[1],
+                    fDecl.Name.Name)
             }
-        case (*ast.Ident):
-            receiverTypeName = t.Name
+            methodCall.Cost = uint(cost)
         }
-
-        _, exists := api.Methods[receiverTypeName]
-        if !exists {
-            api.Methods[receiverTypeName] = make([]*Method, 0)
+        methodCall.Cacheable = CacheableRegexp.MatchString(directive)
+        if m := TTLRegexp.FindStringSubmatch(directive); m != nil {
+            ttl, err := time.ParseDuration(m[1])
+            if err != nil {
+                return fmt.Errorf("Invalid ttl %q of method %s.", m[1],
+                    fDecl.Name.Name)
+            }
+            methodCall.CacheTTL = ttl
         }
-
-        // parse methods
-        methodCall := &Method{Name: fDecl.Name.Name}
+        methodCall.ReturnsError = returnsError(fDecl.Type.Results)
+        methodCall.Result = resultTypeName(f, fDecl.Type.Results)
         actualPos := 0
         // parse parameters
-        for _, paramField := range fDecl.Type.Params.List {
-            parameterTemplate := &Parameter{}
-
-            switch t := paramField.Type.(type) {
-            case (*ast.Ident):
-                parameterTemplate.TypeName = t.Name
-                // check whether it's a basic type
-                _, found := BasicTypes[t.Name]
-                if !found {
-                    return fmt.Errorf("Unsupported Type %s.", t.Name)
-                }
-            case (*ast.StarExpr):
-                // we just assume that this is a struct
-                ident, ok := t.X.(*ast.Ident)
-                if !ok {
-                    return fmt.Errorf(
-                        "Error when parsing StarExpr: %s", t)
+        for i, paramField := range fDecl.Type.Params.List {
+            if isContext(paramField.Type) {
+                if i > 0 || len(paramField.Names) > 1 {
+                    return fmt.Errorf("context.Context must be the first "+
+                        "parameter of method %s.", fDecl.Name.Name)
                 }
-                _, found := BasicTypes[ident.Name]
-                if found {
-                    return fmt.Errorf(
-                        "Pointers to basic types are not supported (*%s at "+
-                        "position %d of method %s).", ident.Name, actualPos,
-                        fDecl.Name.Name)
+                methodCall.TakesContext = true
+                continue
+            }
+            // iterate over names, unnamed parameters have none
+            names := paramField.Names
+            if len(names) == 0 {
+                names = []*ast.Ident{&ast.Ident{}}
+            }
+            for _, name := range names {
+                curParam := &Parameter{Position: uint(actualPos),
+                    Name: name.Name}
+                err := curParam.parseType(f, paramField.Type,
+                    api.TextTypes)
+                if err != nil {
+                    return fmt.Errorf("%s (parameter %s of method %s).", err,
+                        curParam.DisplayName(), fDecl.Name.Name)
                 }
-                parameterTemplate.TypeName = ident.Name
-                parameterTemplate.IsStruct = true
-
-            } // switch parameter type
-
-            // iterate over names
-            var curParam  *Parameter
-            for _ = range paramField.Names {
-                curParam = &Parameter{}
-                *curParam = *parameterTemplate
-                curParam.Position = uint(actualPos)
                 actualPos++
                 methodCall.Params = append(methodCall.Params,
                     curParam)
             }
         } // for parameter field
 
+        // values may contain anything, so search past the previous one
+        for rest := directive; ; {
+            m := DefaultRegexp.FindStringSubmatchIndex(rest)
+            if m == nil { break }
+            name := rest[m[2]:m[3]]
+            param := methodCall.Param(name)
+            if param == nil {
+                return fmt.Errorf("Default for unknown parameter %s of "+
+                    "method %s.", name, fDecl.Name.Name)
+            }
+            var err error
+            if rest, err = param.parseDefault(rest[m[1]:]); err != nil {
+                return fmt.Errorf("%s (parameter %s of method %s).", err,
+                    name, fDecl.Name.Name)
+            }
+        }
+
         api.Methods[receiverTypeName] = append(
             api.Methods[receiverTypeName], methodCall)
     }
+    api.promote()
     return nil
 }
@@ -0,0 +1,183 @@
+package apidistiller
+
+import (
+    "fmt"
+    "sort"
+)
+
+// # Compatibility #
+
+// Change is a difference between two versions of an API. A change is
+// breaking if programs written against the old version may fail with the
+// new one.
+type Change struct {
+    Receiver string // type name of the receiver
+    Method string   // wire name of the method, empty for receivers
+    Message string
+    Breaking bool
+}
+
+func (c *Change) String() string {
+    if c.Breaking { return "breaking: " + c.Message }
+    return "compatible: " + c.Message
+}
+
+// Diff reports the changes from the API from to the API to, sorted by
+// receiver. Methods removed from a receiver while a method of the same
+// parameters is added are reported as renamed.
+func Diff(from *API, to *API) []*Change {
+    var typeNames []string
+    for typeName := range from.Methods {
+        typeNames = append(typeNames, typeName)
+    }
+    for typeName := range to.Methods {
+        if _, found := from.Methods[typeName]; !found {
+            typeNames = append(typeNames, typeName)
+        }
+    }
+    sort.Strings(typeNames)
+
+    var changes []*Change
+    for _, typeName := range typeNames {
+        oldMethods, inOld := from.Methods[typeName]
+        newMethods, inNew := to.Methods[typeName]
+        switch {
+        case !inNew:
+            changes = append(changes, &Change{Receiver: typeName,
+                Message: fmt.Sprintf("Receiver %s removed.", typeName),
+                Breaking: true})
+        case !inOld:
+            changes = append(changes, &Change{Receiver: typeName,
+                Message: fmt.Sprintf("Receiver %s added.", typeName)})
+        default:
+            changes = append(changes,
+                diffMethods(typeName, oldMethods, newMethods)...)
+        }
+    }
+    return changes
+}
+
+// Breaking returns the breaking changes of changes.
+func Breaking(changes []*Change) []*Change {
+    var breaking []*Change
+    for _, change := range changes {
+        if change.Breaking {
+            breaking = append(breaking, change)
+        }
+    }
+    return breaking
+}
+
+func findMethod(methods []*Method, wireName string) *Method {
+    for _, method := range methods {
+        if method.WireName() == wireName { return method }
+    }
+    return nil
+}
+
+// signature describes the parameters of method for the detection of renamed
+// methods.
+func signature(method *Method) string {
+    sig := ""
+    for _, param := range method.Params {
+        sig += param.Name + " " + param.GoType() + ","
+    }
+    return sig
+}
+
+func diffMethods(typeName string, oldMethods []*Method,
+newMethods []*Method) []*Change {
+    var added []*Method
+    for _, method := range newMethods {
+        if findMethod(oldMethods, method.WireName()) == nil {
+            added = append(added, method)
+        }
+    }
+
+    var changes []*Change
+    renamed := make(map[*Method]bool)
+    for _, method := range oldMethods {
+        newMethod := findMethod(newMethods, method.WireName())
+        if newMethod != nil {
+            changes = append(changes,
+                diffParams(typeName, method, newMethod)...)
+            continue
+        }
+
+        // a rename needs a single added method of the same parameters
+        var candidates []*Method
+        for _, a := range added {
+            if !renamed[a] && signature(a) == signature(method) {
+                candidates = append(candidates, a)
+            }
+        }
+        change := &Change{Receiver: typeName, Method: method.WireName(),
+            Breaking: true}
+        if len(candidates) == 1 {
+            renamed[candidates[0]] = true
+            change.Message = fmt.Sprintf("Method %s of %s renamed to %s.",
+                method.WireName(), typeName, candidates[0].WireName())
+        } else {
+            change.Message = fmt.Sprintf("Method %s of %s removed.",
+                method.WireName(), typeName)
+        }
+        changes = append(changes, change)
+    }
+    for _, method := range added {
+        if renamed[method] { continue }
+        changes = append(changes, &Change{Receiver: typeName,
+            Method: method.WireName(),
+            Message: fmt.Sprintf("Method %s of %s added.", method.WireName(),
+                typeName)})
+    }
+    return changes
+}
+
+// required returns the number of arguments a call has to pass.
+func required(method *Method) int {
+    n := 0
+    for _, param := range method.Params {
+        if param.Default == "" && !param.IsVariadic {
+            n++
+        }
+    }
+    return n
+}
+
+// diffParams compares the parameters of old and cur, two versions of the
+// same method.
+func diffParams(typeName string, old *Method, cur *Method) []*Change {
+    var changes []*Change
+    add := func(breaking bool, format string, args ...interface{}) {
+        changes = append(changes, &Change{Receiver: typeName,
+            Method: old.WireName(), Breaking: breaking,
+            Message: fmt.Sprintf(format, args...)})
+    }
+    name := old.WireName() + " of " + typeName
+
+    if len(old.Params) != len(cur.Params) ||
+    required(old) != required(cur) {
+        // calls passing all old arguments still work if the parameters
+        // added to the end are optional
+        breaking := len(cur.Params) < len(old.Params) ||
+            required(cur) > required(old)
+        add(breaking, "Method %s changed arity from %d to %d (%d to %d "+
+            "required).", name, len(old.Params), len(cur.Params),
+            required(old), required(cur))
+    }
+
+    for i := 0; i < len(old.Params) && i < len(cur.Params); i++ {
+        oldParam, newParam := old.Params[i], cur.Params[i]
+        if oldParam.GoType() != newParam.GoType() {
+            add(true, "Parameter %s of %s changed type from %s to %s.",
+                oldParam.DisplayName(), name, oldParam.GoType(),
+                newParam.GoType())
+        }
+        // named arguments break if a parameter is renamed
+        if oldParam.Name != newParam.Name {
+            add(true, "Parameter %s of %s renamed to %s.",
+                oldParam.DisplayName(), name, newParam.DisplayName())
+        }
+    }
+    return changes
+}
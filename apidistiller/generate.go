@@ -0,0 +1,223 @@
+package apidistiller
+
+import (
+    "bytes"
+    "fmt"
+    "go/format"
+    "io"
+    "sort"
+    "strings"
+    "unicode"
+    "unicode/utf8"
+)
+
+// # Code Generation #
+
+// GenerateDecoders writes the go source of package pkgName to w, which
+// declares for every method of api a struct holding its arguments and a
+// function decoding a call into it, e.g. ShopProductFindArgs and
+// DecodeShopProductFind for method Find of example.com/shop.Product. The
+// packages of the receivers and parameter types are imported, see Imports.
+// vesuproPath is the import path of package vesupro.
+//
+// Calls have to be resolved by vesupro.ResolveArguments before they are
+// decoded, so that all arguments are positional and defaults are filled in.
+func (api *API) GenerateDecoders(w io.Writer, pkgName string,
+vesuproPath string) error {
+    typeNames := make([]string, 0, len(api.Methods))
+    for typeName := range api.Methods {
+        typeNames = append(typeNames, typeName)
+    }
+    if len(typeNames) == 0 {
+        return fmt.Errorf("API of package %s has no methods.",
+            api.PackageName)
+    }
+    sort.Strings(typeNames)
+
+    // vesuproPath may be relative, so its name is not taken from it
+    imports := api.imports(map[string]string{"fmt": "fmt",
+        vesuproPath: "vesupro"})
+    g := &generator{buf: &bytes.Buffer{}, imports: imports,
+        used: map[string]bool{"fmt": true, vesuproPath: true}}
+    for _, imp := range imports {
+        if imp.Path != vesuproPath { continue }
+        g.vesupro = imp.Name
+        if imp.Alias != "" {
+            g.vesupro = imp.Alias
+        }
+    }
+    for _, typeName := range typeNames {
+        for _, method := range api.Methods[typeName] {
+            g.decoder(typeName, method)
+        }
+    }
+
+    // only referenced packages may be imported
+    src := &bytes.Buffer{}
+    fmt.Fprintf(src, "// Code generated by vesupro gen. DO NOT EDIT.\n\n")
+    fmt.Fprintf(src, "package %s\n\nimport (\n", pkgName)
+    for _, imp := range imports {
+        if !g.used[imp.Path] { continue }
+        if imp.Alias != "" {
+            fmt.Fprintf(src, "%s ", imp.Alias)
+        }
+        fmt.Fprintf(src, "%q\n", imp.Path)
+    }
+    fmt.Fprintf(src, ")\n")
+    g.buf.WriteTo(src)
+
+    out, err := format.Source(src.Bytes())
+    if err != nil {
+        return fmt.Errorf("Formatting the generated code failed: %s", err)
+    }
+    _, err = w.Write(out)
+    return err
+}
+
+type generator struct {
+    buf *bytes.Buffer
+    imports []*Import
+    used map[string]bool // import paths referenced by the code
+    vesupro string // name of package vesupro in the generated code
+}
+
+// sourceName returns the name of typeName in the generated code and marks
+// its package as used.
+func (g *generator) sourceName(typeName string) string {
+    if path := typePath(typeName); path != "" {
+        g.used[path] = true
+    }
+    return sourceName(g.imports, typeName)
+}
+
+func (g *generator) printf(format string, args ...interface{}) {
+    fmt.Fprintf(g.buf, format, args...)
+}
+
+// identifier returns the go identifier of the source name of typeName, e.g.
+// "ShopProduct" for "example.com/shop.Product".
+func (g *generator) identifier(typeName string) string {
+    id := ""
+    for _, part := range strings.Split(sourceName(g.imports, typeName),
+    ".") {
+        id += exported(part)
+    }
+    return id
+}
+
+func exported(name string) string {
+    r, size := utf8.DecodeRuneInString(name)
+    return string(unicode.ToUpper(r)) + name[size:]
+}
+
+// fieldName returns the name of the struct field holding param.
+func fieldName(param *Parameter) string {
+    if param.Name == "" || param.Name == "_" {
+        return fmt.Sprintf("Arg%d", param.Position)
+    }
+    return exported(param.Name)
+}
+
+// goType returns the type of param in the generated code.
+func (g *generator) goType(param *Parameter) string {
+    typeName := g.sourceName(param.TypeName)
+    if param.IsStruct {
+        typeName = "*" + typeName
+    }
+    if param.IsMap {
+        typeName = "map[string]" + typeName
+    }
+    if param.IsSlice || param.IsVariadic {
+        typeName = "[]" + typeName
+    }
+    return typeName
+}
+
+func (g *generator) decoder(typeName string, method *Method) {
+    name := g.identifier(typeName) + method.Name
+    g.printf("\n// %sArgs holds the arguments of method %s of %s.\n", name,
+        method.Name, typeName)
+    g.printf("type %sArgs struct {\n", name)
+    for _, param := range method.Params {
+        g.printf("%s %s\n", fieldName(param), g.goType(param))
+    }
+    g.printf("}\n")
+
+    required := len(method.Params)
+    if required > 0 && method.Params[required-1].IsVariadic {
+        required--
+    }
+    g.printf("\n// Decode%s decodes the resolved arguments of mc.\n", name)
+    g.printf("func Decode%s(mc *%s.MethodCall) (*%sArgs, error) {\n", name,
+        g.vesupro, name)
+    g.printf("if len(mc.Arguments) < %d", required)
+    if required == len(method.Params) {
+        g.printf(" || len(mc.Arguments) > %d", required)
+    }
+    g.printf(" {\nreturn nil, fmt.Errorf(\"Unexpected number %%d of "+
+        "arguments of %s.\", len(mc.Arguments))\n}\n", method.WireName())
+    g.printf("args := &%sArgs{}\n", name)
+    for i, param := range method.Params {
+        field := "args." + fieldName(param)
+        if !param.IsVariadic {
+            g.convert(param, fmt.Sprintf("mc.Arguments[%d]", i), field,
+                method)
+            continue
+        }
+        elem := *param
+        elem.IsVariadic = false
+        g.printf("for _, arg := range mc.Arguments[%d:] {\n", i)
+        g.printf("var item %s\n", g.goType(&elem))
+        g.convert(&elem, "arg", "item", method)
+        g.printf("%s = append(%s, item)\n}\n", field, field)
+    }
+    g.printf("return args, nil\n}\n")
+}
+
+// intBits maps the integer types to their size in bits, 0 for int and
+// uint, see strconv.ParseInt.
+var intBits = map[string]int{"int": 0, "int8": 8, "int16": 16, "int32": 32,
+    "int64": 64, "rune": 32, "uint": 0, "uint8": 8, "uint16": 16,
+    "uint32": 32, "uint64": 64, "byte": 8}
+
+// convert emits the conversion of the argument arg into target.
+func (g *generator) convert(param *Parameter, arg string, target string,
+method *Method) {
+    g.printf("{\n")
+    switch {
+    case param.IsSlice || param.IsMap:
+        g.printf("err := %s.Unmarshal(&%s)\n", arg, target)
+    case param.IsStruct:
+        g.printf("%s = &%s{}\n", target, g.sourceName(param.TypeName))
+        g.printf("err := %s.Unmarshal(%s)\n", arg, target)
+    case param.IsText && param.TypeName == "time.Time":
+        g.printf("v, err := %s.ToTime()\n%s = v\n", arg, target)
+    case param.IsText:
+        g.printf("err := %s.ToText(&%s)\n", arg, target)
+    default:
+        switch BasicTypes[param.TypeName][0] {
+        case "vesupro.INT":
+            // out of range values fail instead of being truncated
+            conversion := "ToIntN"
+            if strings.HasPrefix(param.TypeName, "uint") ||
+            param.TypeName == "byte" {
+                conversion = "ToUintN"
+            }
+            g.printf("v, err := %s.%s(%d)\n%s = %s(v)\n", arg, conversion,
+                intBits[param.TypeName], target, param.TypeName)
+        case "vesupro.FLOAT":
+            value := "v"
+            if strings.HasPrefix(param.TypeName, "complex") {
+                value = "complex(v, 0)"
+            }
+            g.printf("v, err := %s.ToFloat64()\n%s = %s(%s)\n", arg,
+                target, param.TypeName, value)
+        case "vesupro.TRUE":
+            g.printf("v, err := %s.ToBool()\n%s = v\n", arg, target)
+        case "vesupro.STRING":
+            g.printf("v, err := %s.Unquote()\n%s = v\n", arg, target)
+        }
+    }
+    g.printf("if err != nil {\nreturn nil, fmt.Errorf(\"Argument %s of "+
+        "%s: %%s\", err)\n}\n}\n", param.DisplayName(), method.WireName())
+}
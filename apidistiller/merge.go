@@ -0,0 +1,217 @@
+package apidistiller
+
+import (
+    "fmt"
+    "go/types"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// # Multi-package APIs #
+
+// Qualify returns a copy of api whose type names are qualified by importPath,
+// e.g. "example.com/shop.Product" for Product, so that it can be merged with
+// the APIs of other packages. Qualified names are those of reflect, i.e., the
+// import path and name of a type. Types of other packages such as time.Time
+// are qualified by their own import path while distilling already.
+func (api *API) Qualify(importPath string) *API {
+    qualify := func(name string) string {
+        if name == "" || strings.Contains(name, ".") { return name }
+        return importPath + "." + name
+    }
+
+    q := NewAPI(api.PackageName)
+    q.Packages = map[string]string{importPath: api.PackageName}
+    for typeName, methods := range api.Methods {
+        qMethods := make([]*Method, len(methods))
+        for i, method := range methods {
+            qMethod := *method
+            qMethod.Promoted = qualify(method.Promoted)
+            if types.Universe.Lookup(method.Result) == nil {
+                qMethod.Result = qualify(method.Result)
+            }
+            qMethod.Params = make([]*Parameter, len(method.Params))
+            for j, param := range method.Params {
+                qParam := *param
+                if param.IsStruct || param.IsText {
+                    qParam.TypeName = qualify(param.TypeName)
+                }
+                qMethod.Params[j] = &qParam
+            }
+            qMethods[i] = &qMethod
+        }
+        q.Methods[qualify(typeName)] = qMethods
+    }
+    for typeName, doc := range api.TypeDocs {
+        q.TypeDocs[qualify(typeName)] = doc
+    }
+    for typeName := range api.TextTypes {
+        q.TextTypes[qualify(typeName)] = true
+    }
+    for typeName, embeds := range api.Embeds {
+        for _, embed := range embeds {
+            q.Embeds[qualify(typeName)] = append(q.Embeds[qualify(typeName)],
+                qualify(embed))
+        }
+    }
+    return q
+}
+
+// Merge adds the receivers of other, a qualified API, to api, which is
+// either qualified or empty, e.g. NewAPI(""). Methods are promoted anew, as
+// types may embed types of the other API. It fails without changing api if
+// one of them is not qualified, if an import path is used for different
+// packages or if a method is declared by both APIs.
+func (api *API) Merge(other *API) error {
+    if len(other.Packages) == 0 {
+        return fmt.Errorf("API of package %s is not qualified.",
+            other.PackageName)
+    }
+    if len(api.Packages) == 0 && len(api.Methods) > 0 {
+        return fmt.Errorf("API of package %s is not qualified.",
+            api.PackageName)
+    }
+    for path, name := range other.Packages {
+        if known, found := api.Packages[path]; found && known != name {
+            return fmt.Errorf("Import path %s is used for packages %s and %s.",
+                path, known, name)
+        }
+    }
+    typeNames := make([]string, 0, len(other.Methods))
+    for typeName := range other.Methods {
+        typeNames = append(typeNames, typeName)
+    }
+    sort.Strings(typeNames)
+    for _, typeName := range typeNames {
+        for _, method := range other.Methods[typeName] {
+            if api.Method(typeName, method.WireName()) != nil {
+                return fmt.Errorf("Method %s of %s is declared twice.",
+                    method.Name, typeName)
+            }
+        }
+    }
+
+    if api.Packages == nil {
+        api.Packages = make(map[string]string)
+    }
+    for path, name := range other.Packages {
+        api.Packages[path] = name
+    }
+    for typeName, methods := range other.Methods {
+        api.Methods[typeName] = append(api.Methods[typeName], methods...)
+    }
+    for typeName, doc := range other.TypeDocs {
+        api.TypeDocs[typeName] = doc
+    }
+    for typeName := range other.TextTypes {
+        api.TextTypes[typeName] = true
+    }
+    for typeName, embeds := range other.Embeds {
+        for _, embed := range embeds {
+            if !contains(api.Embeds[typeName], embed) {
+                api.Embeds[typeName] = append(api.Embeds[typeName], embed)
+            }
+        }
+    }
+    api.promote()
+    return nil
+}
+
+// Import is a package imported by code generated for an API.
+type Import struct {
+    Path string
+    Name string  // package name
+    Alias string // set if another imported package has the same name
+}
+
+// Imports returns the packages of api and those of the qualified parameter
+// types of its methods, e.g. "time" for time.Time, sorted by import path.
+// The first package of a name is imported without alias, the others with
+// an alias which is not the name of any imported package.
+func (api *API) Imports() []*Import {
+    return api.imports(nil)
+}
+
+// imports is Imports for generated code importing extra packages besides
+// those of api, given by import path and package name.
+func (api *API) imports(extra map[string]string) []*Import {
+    names := make(map[string]string) // import path -> package name
+    for path, name := range api.Packages {
+        names[path] = name
+    }
+    for _, methods := range api.Methods {
+        for _, method := range methods {
+            for _, param := range method.Params {
+                if path := typePath(param.TypeName); path != "" &&
+                names[path] == "" {
+                    names[path] = pathName(path)
+                }
+            }
+        }
+    }
+    for path, name := range extra {
+        if names[path] == "" {
+            names[path] = name
+        }
+    }
+
+    paths := make([]string, 0, len(names))
+    taken := make(map[string]bool) // package names and aliases
+    for path, name := range names {
+        paths = append(paths, path)
+        taken[name] = true
+    }
+    sort.Strings(paths)
+
+    imports := make([]*Import, len(paths))
+    used := make(map[string]bool)
+    for i, path := range paths {
+        name := names[path]
+        imports[i] = &Import{Path: path, Name: name}
+        if !used[name] {
+            used[name] = true
+            continue
+        }
+        alias := name
+        for n := 2; taken[alias]; n++ {
+            alias = name + strconv.Itoa(n)
+        }
+        taken[alias] = true
+        imports[i].Alias = alias
+    }
+    return imports
+}
+
+// typePath returns the import path of a qualified type name, e.g.
+// "example.com/shop" for "example.com/shop.Product", and "" for types of the
+// distilled package.
+func typePath(typeName string) string {
+    dot := strings.LastIndexByte(typeName, '.')
+    if dot < 0 { return "" }
+    return typeName[:dot]
+}
+
+// pathName returns the conventional package name of an import path, its
+// last element.
+func pathName(path string) string {
+    return path[strings.LastIndexByte(path, '/')+1:]
+}
+
+// SourceName returns how generated code refers to typeName, e.g.
+// "shop.Product" for "example.com/shop.Product". Unqualified names are
+// returned as they are.
+func (api *API) SourceName(typeName string) string {
+    return sourceName(api.Imports(), typeName)
+}
+
+func sourceName(imports []*Import, typeName string) string {
+    path := typePath(typeName)
+    if path == "" { return typeName }
+    for _, imp := range imports {
+        if imp.Path != path { continue }
+        if imp.Alias != "" { return imp.Alias + typeName[len(path):] }
+        return imp.Name + typeName[len(path):]
+    }
+    return typeName
+}
@@ -0,0 +1,191 @@
+package vesupro
+
+import (
+    "./apidistiller"
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "strconv"
+)
+
+// ResolveArguments maps the arguments of call onto the parameters of method
+// and returns a call with positional arguments only. Named arguments are
+// moved to the position of their parameter, omitted parameters are set to
+// their default value. Surplus positional arguments are passed on to a
+// variadic parameter, which cannot be named. It fails for unknown names,
+// arguments given twice and omitted parameters without default with an
+// *ArgumentError.
+//
+// The evaluator resolves the arguments of the methods its API describes
+// before authorizing and dispatching them, so that the Authorizer, the
+// interceptors and the decoders of "vesupro gen" only see positional
+// arguments.
+func ResolveArguments(method *apidistiller.Method,
+call *MethodCall) (*MethodCall, error) {
+    params := method.Params
+    var variadic *apidistiller.Parameter
+    if len(params) > 0 && params[len(params)-1].IsVariadic {
+        variadic = params[len(params)-1]
+        params = params[:len(params)-1]
+    }
+
+    args := make([]*ArgumentToken, len(params))
+    var rest []*ArgumentToken
+    for i, arg := range call.Arguments {
+        pos := i
+        if arg.Name != "" {
+            param := method.Param(arg.Name)
+            if param == nil {
+                return nil, argumentError(call,
+                    "Unknown parameter %s of %s.", arg.Name, call.Name)
+            }
+            if param == variadic {
+                return nil, argumentError(call,
+                    "Variadic parameter %s of %s cannot be named.",
+                    arg.Name, call.Name)
+            }
+            pos = int(param.Position)
+        } else if pos >= len(args) {
+            if variadic == nil {
+                return nil, argumentError(call,
+                    "Too many arguments for %s.", call.Name)
+            }
+            rest = append(rest, &ArgumentToken{TokenType: arg.TokenType,
+                TokenContent: arg.TokenContent})
+            continue
+        }
+        if args[pos] != nil {
+            return nil, argumentError(call, "Argument %s of %s given twice.",
+                params[pos].DisplayName(), call.Name)
+        }
+        args[pos] = &ArgumentToken{TokenType: arg.TokenType,
+            TokenContent: arg.TokenContent}
+    }
+
+    for i, param := range params {
+        if args[i] != nil { continue }
+        if param.Default == "" {
+            return nil, argumentError(call, "Missing argument %s of %s.",
+                param.DisplayName(), call.Name)
+        }
+        arg, err := defaultArgument(param)
+        if err != nil { return nil, err }
+        args[i] = arg
+    }
+    return &MethodCall{Name: call.Name, Arguments: append(args, rest...)},
+        nil
+}
+
+// defaultArgument scans the default value of param.
+func defaultArgument(param *apidistiller.Parameter) (*ArgumentToken, error) {
+    t := NewTokenizer(bytes.NewBufferString(param.Default))
+    tok := Scan(t, true)
+    arg := &ArgumentToken{TokenType: tok, TokenContent: t.CurrentToken()}
+    switch tok {
+    case INT, FLOAT, STRING, TRUE, FALSE, JSON:
+        if Scan(t, true) == EOF { return arg, nil }
+    }
+    return nil, fmt.Errorf("Invalid default %s of parameter %s.",
+        param.Default, param.DisplayName())
+}
+
+// ArgumentError is returned if the arguments of a call do not match the
+// parameters of its method.
+type ArgumentError struct {
+    Method string
+    Message string
+}
+
+func (e *ArgumentError) Error() string { return e.Message }
+
+func argumentError(call *MethodCall, format string,
+args ...interface{}) error {
+    return &ArgumentError{Method: call.Name,
+        Message: fmt.Sprintf(format, args...)}
+}
+
+// resolveDefinitions returns defs with the calls resolved whose method the
+// evaluator's API describes and which have named arguments or omit
+// parameters with a default. The type of the first receiver is taken from
+// the symbol table, those of later receivers from the Result of the
+// previous method. Other calls, e.g. on receivers without API or on the
+// results of methods returning an interface, are dispatched unchanged, so
+// that receivers may interpret argument names themselves. defs is not
+// modified, as prepared queries evaluate their definitions repeatedly.
+func (e *Evaluator) resolveDefinitions(
+defs []*Definition) ([]*Definition, error) {
+    if e.API == nil { return defs, nil }
+    resolved := make([]*Definition, len(defs))
+    for i, def := range defs {
+        resolved[i] = def
+        rcvObj, found := e.receiver(def.ReceiverName)
+        if !found { continue }
+
+        typeName := APITypeName(e.API, rcvObj)
+        for j, call := range def.MethodCalls {
+            method := e.API.Method(typeName, call.Name)
+            if method == nil { break }
+            typeName = method.Result
+            if !needsResolution(method, call) { continue }
+
+            rCall, err := ResolveArguments(method, call)
+            if err != nil { return nil, err }
+            if resolved[i] == def {
+                resolved[i] = NewDefinition(def.TargetName, def.ReceiverName,
+                    append([]*MethodCall{}, def.MethodCalls...))
+            }
+            resolved[i].MethodCalls[j] = rCall
+        }
+    }
+    return resolved, nil
+}
+
+// needsResolution reports whether call has named arguments or omits
+// parameters of method with a default.
+func needsResolution(method *apidistiller.Method, call *MethodCall) bool {
+    for _, arg := range call.Arguments {
+        if arg.Name != "" { return true }
+    }
+    for i := len(call.Arguments); i < len(method.Params); i++ {
+        if method.Params[i].Default != "" { return true }
+    }
+    return false
+}
+
+// ArgumentFromJSON converts a JSON value into an argument token. Numbers
+// become INT or FLOAT arguments, strings STRING, booleans TRUE or FALSE and
+// objects and arrays JSON arguments. null is not supported by the grammar and
+// rejected.
+func ArgumentFromJSON(value json.RawMessage) (*ArgumentToken, error) {
+    content := &bytes.Buffer{}
+    if err := json.Compact(content, value); err != nil { return nil, err }
+    arg := &ArgumentToken{TokenContent: content.Bytes()}
+    switch content.Bytes()[0] {
+    case '{', '[':
+        arg.TokenType = JSON
+    case '"':
+        arg.TokenType = STRING
+    case 't':
+        arg.TokenType = TRUE
+    case 'f':
+        arg.TokenType = FALSE
+    case 'n':
+        return nil, fmt.Errorf("Null is not supported.")
+    default:
+        n := json.Number(content.String())
+        if i, err := n.Int64(); err == nil {
+            arg.TokenType = INT
+            arg.TokenContent = []byte(strconv.FormatInt(i, 10))
+            return arg, nil
+        }
+        f, err := n.Float64()
+        if err != nil { return nil, err }
+        s := strconv.FormatFloat(f, 'g', -1, 64)
+        if !bytes.ContainsAny([]byte(s), ".eE") {
+            s += ".0"
+        }
+        arg.TokenType = FLOAT
+        arg.TokenContent = []byte(s)
+    }
+    return arg, nil
+}
@@ -0,0 +1,344 @@
+package vesupro_test
+
+import (
+    "./"
+    "./apidistiller"
+    "testing"
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "reflect"
+    "go/parser"
+    "go/token"
+    "strings"
+)
+
+// Search echoes the arguments it is called with.
+type Search struct{}
+
+type echoedArguments []string
+
+func (s *Search) Dispatch(mc *vesupro.MethodCall) (vesupro.VesuproObject, error) {
+    args := make(echoedArguments, len(mc.Arguments))
+    for i, arg := range mc.Arguments {
+        args[i] = arg.Name + string(arg.TokenContent)
+    }
+    return args, nil
+}
+
+func (s *Search) MarshalJSON() ([]byte, error) {
+    return []byte(`"search"`), nil
+}
+
+func (a echoedArguments) Dispatch(mc *vesupro.MethodCall) (vesupro.VesuproObject, error) {
+    return a, nil
+}
+
+func (a echoedArguments) MarshalJSON() ([]byte, error) {
+    return json.Marshal([]string(a))
+}
+
+const searchSource = `package search
+
+// vesupro: export default limit=10 default exact=false default lang="en"
+func (s *Search) Run(query string, limit int, exact bool, lang string) {}
+
+// vesupro: export
+func (s *Search) Count(query string) {}
+
+// vesupro: export default scope="all"
+func (s *Search) In(scope string) *Search {}
+
+// vesupro: export
+func (s *Search) Tagged(query string, tags ...string) {}
+
+// vesupro: export
+func (s *Search) Similar(users []*User, limit int) {}
+
+// vesupro: export
+func (s *Search) Since(from time.Time, owner ID, filters map[string]string) {}
+
+func (id *ID) UnmarshalText(text []byte) error { return nil }
+`
+
+func newSearchEvaluator(t *testing.T) *vesupro.Evaluator {
+    f, err := parser.ParseFile(token.NewFileSet(), "search.go", searchSource,
+        parser.ParseComments)
+    if err != nil { t.Fatal(err) }
+    api := apidistiller.NewAPI("search")
+    if err := api.DistillFromAstFile(f); err != nil { t.Fatal(err) }
+
+    ev := vesupro.NewEvaluator(map[string]vesupro.VesuproObject{
+        "search": &Search{},
+    })
+    ev.API = api
+    return ev
+}
+
+func TestEvaluator_NamedArguments(t *testing.T) {
+    tests := []struct {
+        in string
+        out string
+        err string
+    }{
+        {in: `r := search.run(exact: true, query: "x");`,
+        out: `{"r":["\"x\"","10","true","\"en\""]}`},
+        {in: `r := search.run("x", 5);`,
+        out: `{"r":["\"x\"","5","false","\"en\""]}`},
+        {in: `r := search.run("x", 5, true, "de");`,
+        out: `{"r":["\"x\"","5","true","\"de\""]}`},
+        // methods without defaults are dispatched unchanged
+        {in: `r := search.count();`, out: `{"r":[]}`},
+        // so are methods missing in the API
+        {in: `r := search.other(name: 1);`, out: `{"r":["name1"]}`},
+        {in: `r := search.tagged("x", "a", "b");`,
+        out: `{"r":["\"x\"","\"a\"","\"b\""]}`},
+        {in: `r := search.tagged(query: "x");`, out: `{"r":["\"x\""]}`},
+        {in: `r := search.similar(limit: 3, users: [{"id": 1}]);`,
+        out: `{"r":["[{\"id\": 1}]","3"]}`},
+        {in: `r := search.tagged("x", "a", tags: "b");`,
+        err: "Variadic parameter tags of tagged cannot be named."},
+        {in: `r := search.run(limit: 5);`,
+        err: "Missing argument query of run."},
+        {in: `r := search.run("x", query: "y");`,
+        err: "Argument query of run given twice."},
+        {in: `r := search.run("x", page: 2);`,
+        err: "Unknown parameter page of run."},
+        {in: `r := search.count("x", query: "y");`,
+        err: "Argument query of count given twice."},
+        {in: `r := search.count("x", "y", query: "z");`,
+        err: "Too many arguments for count."},
+    }
+
+    ev := newSearchEvaluator(t)
+    for i, tt := range tests {
+        out := &bytes.Buffer{}
+        err := ev.Evaluate(out, bytes.NewBufferString(tt.in))
+        if tt.err != "" {
+            if err == nil || err.Error() != tt.err {
+                t.Errorf("%d. error mismatch %q != %v.", i, tt.err, err)
+            }
+        } else if err != nil {
+            t.Errorf("%d. error: %q", i, err)
+        } else if tt.out != out.String() {
+            t.Errorf("%d. in/out mismatch %q != %q.", i, tt.out, out.String())
+        }
+    }
+}
+
+func TestEvaluator_NamedArgumentsResolvedBeforeAuthorization(t *testing.T) {
+    var authorized, intercepted []string
+    record := func(calls *[]string, call *vesupro.MethodCall) {
+        args := make([]string, len(call.Arguments))
+        for i, arg := range call.Arguments {
+            args[i] = arg.Name + string(arg.TokenContent)
+        }
+        *calls = append(*calls, call.Name+"("+strings.Join(args, ", ")+")")
+    }
+
+    ev := newSearchEvaluator(t)
+    ev.Authorizer = vesupro.AuthorizerFunc(func(ctx context.Context,
+    identity interface{}, receiver string, call *vesupro.MethodCall) error {
+        record(&authorized, call)
+        return nil
+    })
+    ev.Interceptors = []vesupro.Interceptor{func(ctx context.Context,
+    call *vesupro.Call, next vesupro.Invoker) (vesupro.VesuproObject, error) {
+        if call.Kind == vesupro.DispatchCall {
+            record(&intercepted, call.MethodCall)
+        }
+        return next(ctx, call)
+    }}
+
+    in := `r := search.in().run(exact: true, query: "x");`
+    if err := ev.Evaluate(&bytes.Buffer{}, bytes.NewBufferString(in));
+    err != nil {
+        t.Fatalf("error: %q", err)
+    }
+    exp := []string{`in("all")`, `run("x", 10, true, "en")`}
+    if !reflect.DeepEqual(exp, authorized) {
+        t.Errorf("authorized calls mismatch %q != %q.", exp, authorized)
+    }
+    if !reflect.DeepEqual(exp, intercepted) {
+        t.Errorf("intercepted calls mismatch %q != %q.", exp, intercepted)
+    }
+}
+
+func TestDistill_Errors(t *testing.T) {
+    tests := []struct {
+        replace string
+        by string
+        err string
+    }{
+        {replace: "default limit=10", by: "default page=10",
+        err: "Default for unknown parameter page of method Run."},
+        {replace: `default lang="en"`, by: "default lang=en",
+        err: "Invalid default (parameter lang of method Run)."},
+        {replace: "default limit=10", by: `default limit="10"`,
+        err: `Default "10" does not match type int (parameter limit of ` +
+            "method Run)."},
+        {replace: "default limit=10", by: "default limit=1.5",
+        err: "Default 1.5 does not match type int (parameter limit of " +
+            "method Run)."},
+        {replace: "default exact=false", by: "default exact=null",
+        err: "Default null does not match type bool (parameter exact of " +
+            "method Run)."},
+        {replace: "// vesupro: export\nfunc (s *Search) Tagged",
+        by: "// vesupro: export default tags=\"x\"\nfunc (s *Search) Tagged",
+        err: "Variadic parameters cannot have a default (parameter tags of " +
+            "method Tagged)."},
+        {replace: "tags ...string", by: "tags map[int]string",
+        err: "Map keys must be strings, not int (parameter tags of method " +
+            "Tagged)."},
+        {replace: "tags ...string", by: "tags map[string]*User",
+        err: "Unsupported type expression *User (parameter tags of method " +
+            "Tagged)."},
+        {replace: "owner ID", by: "owner uuid.UUID",
+        err: "Unsupported Type uuid.UUID (parameter owner of method Since)."},
+        {replace: "func (id *ID) UnmarshalText", by: "func (id ID) Text",
+        err: "Unsupported Type ID (parameter owner of method Since)."},
+        {replace: "tags ...string", by: "tags [2]string",
+        err: "Arrays are not supported, use a slice (parameter tags of " +
+            "method Tagged)."},
+        {replace: "query string, tags ...string", by: "string, ...*int",
+        err: "Pointers to basic types such as *int are not supported " +
+            "(parameter at position 1 of method Tagged)."},
+        {replace: "users []*User", by: "users [][]int",
+        err: "Unsupported type expression []int (parameter users of " +
+            "method Similar)."},
+    }
+
+    for i, tt := range tests {
+        src := strings.Replace(searchSource, tt.replace, tt.by, 1)
+        f, err := parser.ParseFile(token.NewFileSet(), "search.go", src,
+            parser.ParseComments)
+        if err != nil { t.Fatal(err) }
+        err = apidistiller.NewAPI("search").DistillFromAstFile(f)
+        if err == nil || err.Error() != tt.err {
+            t.Errorf("%d. error mismatch %q != %v.", i, tt.err, err)
+        }
+    }
+}
+
+func TestDistill_Defaults(t *testing.T) {
+    src := strings.Replace(searchSource,
+        "// vesupro: export\nfunc (s *Search) Since",
+        "// vesupro: export default filters={\"a b\": \"default c=1\"} "+
+        "default owner=\"x\"\nfunc (s *Search) Since", 1)
+    f, err := parser.ParseFile(token.NewFileSet(), "search.go", src,
+        parser.ParseComments)
+    if err != nil { t.Fatal(err) }
+    api := apidistiller.NewAPI("search")
+    if err := api.DistillFromAstFile(f); err != nil { t.Fatal(err) }
+
+    var defaults []string
+    for _, param := range api.Method("Search", "since").Params {
+        defaults = append(defaults, param.Default)
+    }
+    exp := []string{"", `"x"`, `{"a b": "default c=1"}`}
+    if !reflect.DeepEqual(exp, defaults) {
+        t.Errorf("defaults mismatch %q != %q.", exp, defaults)
+    }
+}
+
+func TestResolveArguments_InvalidDefault(t *testing.T) {
+    method := &apidistiller.Method{Name: "Run", Params: []*apidistiller.Parameter{
+        &apidistiller.Parameter{Name: "limit", Default: "ten"},
+    }}
+    _, err := vesupro.ResolveArguments(method, vesupro.NewMethodCall("run"))
+    if err == nil || err.Error() != "Invalid default ten of parameter limit." {
+        t.Errorf("unexpected error %v.", err)
+    }
+}
+
+func TestParameter_GoType(t *testing.T) {
+    ev := newSearchEvaluator(t)
+    var types []string
+    for _, name := range []string{"tagged", "similar", "since"} {
+        for _, param := range ev.API.Method("Search", name).Params {
+            types = append(types, param.GoType())
+        }
+    }
+    exp := "[string ...string []*User int time.Time ID map[string]string]"
+    if fmt.Sprint(types) != exp {
+        t.Errorf("types mismatch %q != %q.", exp, fmt.Sprint(types))
+    }
+}
+
+func TestArgumentToken_Unmarshal(t *testing.T) {
+    var ids []int
+    arg := &vesupro.ArgumentToken{TokenType: vesupro.JSON,
+        TokenContent: []byte("[1, 2]")}
+    if err := arg.Unmarshal(&ids); err != nil {
+        t.Fatalf("error: %q", err)
+    }
+    if fmt.Sprint(ids) != "[1 2]" {
+        t.Errorf("unexpected ids %v.", ids)
+    }
+}
+
+// id is a custom id type of the form "u<number>".
+type id int
+
+func (i *id) UnmarshalText(text []byte) error {
+    _, err := fmt.Sscanf(string(text), "u%d", (*int)(i))
+    if err != nil { return fmt.Errorf("Invalid id %q.", text) }
+    return nil
+}
+
+func TestArgumentToken_Convert(t *testing.T) {
+    tests := []struct {
+        tok vesupro.Token
+        content string
+        convert func(arg *vesupro.ArgumentToken) (interface{}, error)
+        out string
+        err string
+    }{
+        {vesupro.JSON, `{"lang": "en"}`, toStringMap, "map[lang:en]", ""},
+        {vesupro.JSON, `{"limit": 10}`, toStringMap, "",
+            `ToStringMap(): {"limit": 10} is not an object of strings.`},
+        {vesupro.JSON, `["en"]`, toStringMap, "",
+            "ToStringMap(): Cannot convert Token of type 19 to map."},
+        {vesupro.STRING, `"2017-03-01T12:00:00+01:00"`, toTime,
+            "2017-03-01 11:00:00 +0000 UTC", ""},
+        {vesupro.STRING, `"2017-03-01"`, toTime, "",
+            `ToTime(): "2017-03-01" is not an RFC 3339 time.`},
+        {vesupro.INT, `1488366000`, toTime, "",
+            "ToTime(): Cannot convert Token of type 7 to string."},
+        {vesupro.STRING, `"u42"`, toID, "42", ""},
+        {vesupro.STRING, `"42"`, toID, "",
+            `ToText(): Cannot unmarshal "42": Invalid id "42".`},
+    }
+
+    for i, tt := range tests {
+        arg := &vesupro.ArgumentToken{TokenType: tt.tok,
+            TokenContent: []byte(tt.content)}
+        out, err := tt.convert(arg)
+        if tt.err != "" {
+            if err == nil || err.Error() != tt.err {
+                t.Errorf("%d. error mismatch %q != %v.", i, tt.err, err)
+            }
+        } else if err != nil {
+            t.Errorf("%d. error: %q", i, err)
+        } else if fmt.Sprint(out) != tt.out {
+            t.Errorf("%d. in/out mismatch %q != %q.", i, tt.out,
+                fmt.Sprint(out))
+        }
+    }
+}
+
+func toStringMap(arg *vesupro.ArgumentToken) (interface{}, error) {
+    return arg.ToStringMap()
+}
+
+func toTime(arg *vesupro.ArgumentToken) (interface{}, error) {
+    t, err := arg.ToTime()
+    return t.UTC(), err
+}
+
+func toID(arg *vesupro.ArgumentToken) (interface{}, error) {
+    var i id
+    err := arg.ToText(&i)
+    return int(i), err
+}
@@ -0,0 +1,69 @@
+package vesupro
+
+import (
+    "context"
+    "fmt"
+)
+
+// Authorizer decides whether the caller identified by identity may invoke
+// call. receiver is the name of the receiver the definition containing call
+// starts at, i.e., `admin` for both calls in `v := admin.users().delete(1);`,
+// or the receiver it derives from, see Evaluator.Origins.
+type Authorizer interface {
+    Authorize(ctx context.Context, identity interface{}, receiver string,
+        call *MethodCall) error
+}
+
+// AuthorizerFunc adapts a function to the Authorizer interface.
+type AuthorizerFunc func(ctx context.Context, identity interface{},
+    receiver string, call *MethodCall) error
+
+func (f AuthorizerFunc) Authorize(ctx context.Context, identity interface{},
+receiver string, call *MethodCall) error {
+    return f(ctx, identity, receiver, call)
+}
+
+// AuthorizationError is returned if a method call has been denied.
+type AuthorizationError struct {
+    Receiver string
+    Method string
+    Reason string
+}
+
+func (e *AuthorizationError) Error() string {
+    return fmt.Sprintf("Calling %s on %s is not authorized: %s", e.Method,
+        e.Receiver, e.Reason)
+}
+
+type identityKey struct{}
+
+// WithIdentity returns a copy of ctx carrying the identity of the caller.
+func WithIdentity(ctx context.Context, identity interface{}) context.Context {
+    return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// IdentityFromContext returns the identity stored by WithIdentity or nil.
+func IdentityFromContext(ctx context.Context) interface{} {
+    return ctx.Value(identityKey{})
+}
+
+// authorize checks all method calls of defs. origin maps the receiver names
+// to the receivers they derive from, see Evaluator.Origins. Errors returned
+// by the authorizer which are not an *AuthorizationError are wrapped in one.
+func authorize(ctx context.Context, a Authorizer, defs []*Definition,
+origin func(name string) string) error {
+    identity := IdentityFromContext(ctx)
+    for _, def := range defs {
+        receiver := origin(def.ReceiverName)
+        for _, call := range def.MethodCalls {
+            err := a.Authorize(ctx, identity, receiver, call)
+            if err == nil { continue }
+            if authErr, ok := err.(*AuthorizationError); ok {
+                return authErr
+            }
+            return &AuthorizationError{Receiver: receiver,
+                Method: call.Name, Reason: err.Error()}
+        }
+    }
+    return nil
+}
@@ -0,0 +1,118 @@
+package vesupro_test
+
+import (
+    "./"
+    "testing"
+    "bytes"
+    "context"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "reflect"
+)
+
+type authCall struct {
+    identity interface{}
+    receiver string
+    method string
+    args int
+}
+
+func TestEvaluator_Authorizer(t *testing.T) {
+    var calls []authCall
+    admin := &countingObject{}
+    ev := vesupro.NewEvaluator(map[string]vesupro.VesuproObject{
+        "admin": admin, "users": &countingObject{},
+    })
+    ev.Authorizer = vesupro.AuthorizerFunc(func(ctx context.Context,
+    identity interface{}, receiver string, call *vesupro.MethodCall) error {
+        calls = append(calls, authCall{identity, receiver, call.Name,
+            len(call.Arguments)})
+        switch {
+        case receiver == "admin" && identity != "root":
+            return &vesupro.AuthorizationError{Receiver: receiver,
+                Method: call.Name, Reason: "admin only"}
+        case call.Name == "forbidden":
+            return fmt.Errorf("forbidden for everyone")
+        }
+        return nil
+    })
+
+    tests := []struct {
+        identity interface{}
+        in string
+        calls []authCall
+        denied bool
+    }{
+        {identity: "root", in: `v1 := admin.users().deleteUser(1, true);`,
+        calls: []authCall{{"root", "admin", "users", 0},
+            {"root", "admin", "deleteUser", 2}}},
+        {identity: "alice", in: `v1 := users.get(1); v2 := admin.users();`,
+        calls: []authCall{{"alice", "users", "get", 1},
+            {"alice", "admin", "users", 0}},
+        denied: true},
+        {identity: "root", in: `v1 := users.forbidden();`,
+        calls: []authCall{{"root", "users", "forbidden", 0}},
+        denied: true},
+    }
+
+    for i, tt := range tests {
+        calls = nil
+        admin.dispatched = 0
+        ctx := vesupro.WithIdentity(context.Background(), tt.identity)
+        _, err := ev.EvaluateContext(ctx, &bytes.Buffer{},
+            bytes.NewBufferString(tt.in))
+
+        _, isAuthErr := err.(*vesupro.AuthorizationError)
+        switch {
+        case !tt.denied && err != nil:
+            t.Errorf("%d. error: %q", i, err)
+        case tt.denied && !isAuthErr:
+            t.Errorf("%d. expected AuthorizationError, got %q", i, err)
+        case tt.denied && admin.dispatched != 0:
+            t.Errorf("%d. dispatched despite denied call.", i)
+        case !reflect.DeepEqual(tt.calls, calls):
+            t.Errorf("%d. calls mismatch %v != %v.", i, tt.calls, calls)
+        }
+    }
+}
+
+func TestHandler_Identify(t *testing.T) {
+    h := vesupro.NewHandler(map[string]vesupro.VesuproObject{
+        "admin": &countingObject{},
+    })
+    h.Identify = func(r *http.Request) (interface{}, error) {
+        user := r.Header.Get("X-User")
+        if user == "" {
+            return nil, fmt.Errorf("Missing X-User header.")
+        }
+        return user, nil
+    }
+    h.Evaluator.Authorizer = vesupro.AuthorizerFunc(func(ctx context.Context,
+    identity interface{}, receiver string, call *vesupro.MethodCall) error {
+        if identity != "root" {
+            return fmt.Errorf("admin only")
+        }
+        return nil
+    })
+
+    tests := []struct {
+        user string
+        status int
+    }{
+        {user: "", status: http.StatusUnauthorized},
+        {user: "alice", status: http.StatusForbidden},
+        {user: "root", status: http.StatusOK},
+    }
+
+    for i, tt := range tests {
+        req := httptest.NewRequest("POST", "/",
+            bytes.NewBufferString(`v1 := admin.deleteUser(1);`))
+        req.Header.Set("X-User", tt.user)
+        rec := httptest.NewRecorder()
+        h.ServeHTTP(rec, req)
+        if tt.status != rec.Code {
+            t.Errorf("%d. status mismatch %d != %d.", i, tt.status, rec.Code)
+        }
+    }
+}
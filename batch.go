@@ -0,0 +1,139 @@
+package vesupro
+
+import (
+    "context"
+    "fmt"
+)
+
+// BatchDispatcher is implemented by receivers which can dispatch several
+// calls at once, e.g. to load a number of records with a single query.
+// BatchDispatch returns one object and one error per call, in order. A nil
+// error slice means that all calls succeeded.
+type BatchDispatcher interface {
+    VesuproObject
+    BatchDispatch(calls []*MethodCall) ([]VesuproObject, []error)
+}
+
+// BatchResult is the result of a BatchDispatchCall.
+type BatchResult struct {
+    Objects []VesuproObject
+    Errors []error
+}
+
+func (r *BatchResult) Dispatch(c *MethodCall) (VesuproObject, error) {
+    return nil, fmt.Errorf("Cannot dispatch %s on a batch result.", c.Name)
+}
+
+func (r *BatchResult) MarshalJSON() ([]byte, error) {
+    return nil, fmt.Errorf("Cannot marshal a batch result.")
+}
+
+func invokeBatch(call *Call) (VesuproObject, error) {
+    dispatcher, ok := call.Object.(BatchDispatcher)
+    if !ok {
+        return nil, fmt.Errorf("Receiver %s cannot dispatch batches.",
+            call.Receiver)
+    }
+    objs, errs := dispatcher.BatchDispatch(call.Batch)
+    for i, err := range errs {
+        if err != nil && i < len(call.Batch) {
+            errs[i] = &DispatchError{Receiver: call.Receiver,
+                Method: call.Batch[i].Name, Err: err}
+        }
+    }
+    return &BatchResult{Objects: objs, Errors: errs}, nil
+}
+
+// batchedCall is the outcome of the first call of a batched definition.
+type batchedCall struct {
+    obj VesuproObject
+    err error
+}
+
+// batch is a group of definitions whose first calls are dispatched at once.
+// It is dispatched when the first of its definitions is evaluated.
+type batch struct {
+    receiver string
+    defs []*Definition
+    results map[*Definition]*batchedCall // nil until dispatched
+}
+
+// batches groups the definitions starting at a BatchDispatcher by receiver
+// and name of the first method and maps each grouped definition to its
+// batch. Only first calls are batched, as the receivers of later calls are
+// not known before execution. Definitions served by the result cache and
+// groups of a single definition are left out.
+func (e *Evaluator) batches(defs []*Definition) map[*Definition]*batch {
+    var groups []*batch
+    index := make(map[string]*batch)
+    for _, def := range defs {
+        if len(def.MethodCalls) == 0 { continue }
+        _, ok := e.SymTable[def.ReceiverName].(BatchDispatcher)
+        if !ok { continue }
+        if e.Cache != nil {
+            if _, cacheable := e.Cache.ttl(def, e.SymTable); cacheable {
+                continue
+            }
+        }
+
+        key := def.ReceiverName + "." + def.MethodCalls[0].Name
+        b, found := index[key]
+        if !found {
+            b = &batch{receiver: def.ReceiverName}
+            index[key] = b
+            groups = append(groups, b)
+        }
+        b.defs = append(b.defs, def)
+    }
+
+    batched := make(map[*Definition]*batch)
+    for _, b := range groups {
+        if len(b.defs) < 2 { continue }
+        for _, def := range b.defs {
+            batched[def] = b
+        }
+    }
+    return batched
+}
+
+// dispatchBatch returns the outcome of the first call of def, dispatching
+// the first calls of all definitions of b through invoker unless that
+// happened before. An error of the whole batch is reported for each of its
+// definitions.
+func (e *Evaluator) dispatchBatch(ctx context.Context, invoker Invoker,
+b *batch, def *Definition) (*batchedCall, error) {
+    if b.results != nil { return b.results[def], nil }
+    if err := ctx.Err(); err != nil { return nil, err }
+
+    b.results = make(map[*Definition]*batchedCall)
+    calls := make([]*MethodCall, len(b.defs))
+    for i, member := range b.defs {
+        calls[i] = member.MethodCalls[0]
+    }
+    rcvObj := e.SymTable[b.receiver]
+
+    out, err := invoker(ctx, &Call{Kind: BatchDispatchCall,
+        Receiver: e.origin(b.receiver), Object: rcvObj, Batch: calls})
+    result, ok := out.(*BatchResult)
+    if err == nil && (!ok || len(result.Objects) != len(calls) ||
+        (result.Errors != nil && len(result.Errors) != len(calls))) {
+        err = fmt.Errorf("Batch dispatch on %s returned no result for "+
+            "each of %d calls.", b.receiver, len(calls))
+    }
+
+    for i, member := range b.defs {
+        bc := &batchedCall{err: err}
+        if err == nil {
+            bc.obj = result.Objects[i]
+            if result.Errors != nil {
+                bc.err = result.Errors[i]
+            }
+            if bc.err == nil && bc.obj == nil {
+                bc.err = fmt.Errorf("Dispatching %s returned no object.",
+                    calls[i].Name)
+            }
+        }
+        b.results[member] = bc
+    }
+    return b.results[def], nil
+}
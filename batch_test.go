@@ -0,0 +1,141 @@
+package vesupro_test
+
+import (
+    "./"
+    "testing"
+    "bytes"
+    "context"
+    "fmt"
+    "strings"
+)
+
+// Records dispatches get calls in batches.
+type Records struct {
+    batches []int
+}
+
+type record int64
+
+func (r *Records) Dispatch(mc *vesupro.MethodCall) (vesupro.VesuproObject, error) {
+    objs, errs := r.BatchDispatch([]*vesupro.MethodCall{mc})
+    return objs[0], errs[0]
+}
+
+func (r *Records) BatchDispatch(calls []*vesupro.MethodCall) ([]vesupro.VesuproObject, []error) {
+    r.batches = append(r.batches, len(calls))
+    objs := make([]vesupro.VesuproObject, len(calls))
+    errs := make([]error, len(calls))
+    for i, mc := range calls {
+        id, err := mc.Arguments[0].ToInt64()
+        if err == nil && id < 0 {
+            err = fmt.Errorf("No record %d.", id)
+        }
+        if err != nil {
+            errs[i] = err
+            continue
+        }
+        objs[i] = record(id)
+    }
+    return objs, errs
+}
+
+func (r *Records) MarshalJSON() ([]byte, error) {
+    return []byte(`"records"`), nil
+}
+
+func (r record) Dispatch(mc *vesupro.MethodCall) (vesupro.VesuproObject, error) {
+    return record(r * 10), nil
+}
+
+func (r record) MarshalJSON() ([]byte, error) {
+    return []byte(fmt.Sprint(int64(r))), nil
+}
+
+func TestEvaluator_Batch(t *testing.T) {
+    tests := []struct {
+        in string
+        out string
+        err string
+        batches []int
+    }{
+        {in: `a := records.get(1); b := records.get(2); c := records.get(3);`,
+        out: `{"a":1,` + "\n" + `"b":2,` + "\n" + `"c":3}`,
+        batches: []int{3}},
+        // only first calls of the same method are batched
+        {in: `a := records.get(1).next(); b := records.find(2); ` +
+            `c := records.get(3);`,
+        out: `{"a":10,` + "\n" + `"b":2,` + "\n" + `"c":3}`,
+        batches: []int{2, 1}},
+        // errors stay with their target
+        {in: `a := records.get(1); b := records.get(-2); c := records.get(3);`,
+        out: `{"a":1`, err: "records.get: No record -2.",
+        batches: []int{3}},
+        // batches are dispatched in program order
+        {in: `x := nobody.get(1); a := records.get(1); b := records.get(2);`,
+        err: "Receiver not found nobody.", batches: []int{}},
+    }
+
+    for i, tt := range tests {
+        records := &Records{}
+        ev := vesupro.NewEvaluator(map[string]vesupro.VesuproObject{
+            "records": records,
+        })
+        out := &bytes.Buffer{}
+        err := ev.Evaluate(out, bytes.NewBufferString(tt.in))
+        if tt.err == "" && err != nil {
+            t.Errorf("%d. error: %q", i, err)
+        } else if tt.err != "" && (err == nil || err.Error() != tt.err) {
+            t.Errorf("%d. error mismatch %q != %v.", i, tt.err, err)
+        } else if !strings.HasPrefix(out.String(), tt.out) {
+            t.Errorf("%d. in/out mismatch %q != %q.", i, tt.out, out.String())
+        } else if fmt.Sprint(records.batches) != fmt.Sprint(tt.batches) {
+            t.Errorf("%d. batches mismatch %v != %v.", i, tt.batches,
+            records.batches)
+        }
+    }
+}
+
+func TestEvaluator_BatchCancelled(t *testing.T) {
+    records := &Records{}
+    ev := vesupro.NewEvaluator(map[string]vesupro.VesuproObject{
+        "records": records,
+    })
+    ctx, cancel := context.WithCancel(context.Background())
+    cancel()
+
+    _, err := ev.EvaluateContext(ctx, &bytes.Buffer{},
+        bytes.NewBufferString(`a := records.get(1); b := records.get(2);`))
+    if err != context.Canceled {
+        t.Errorf("error mismatch %q != %v.", context.Canceled, err)
+    }
+    if len(records.batches) != 0 {
+        t.Errorf("dispatched batches %v.", records.batches)
+    }
+}
+
+func TestEvaluator_BatchInterceptor(t *testing.T) {
+    var log []string
+    ev := vesupro.NewEvaluator(map[string]vesupro.VesuproObject{
+        "records": &Records{},
+    })
+    metrics := &recordingMetrics{}
+    ev.Metrics = metrics
+    ev.Interceptors = []vesupro.Interceptor{logging("log", &log)}
+
+    err := ev.Evaluate(&bytes.Buffer{},
+        bytes.NewBufferString(`a := records.get(1); b := records.get(-1);`))
+    if err == nil {
+        t.Fatalf("expected error.")
+    }
+
+    expLog := []string{"log before .batch(2)", "log after .batch(2)",
+        "log before a.marshal", "log after a.marshal"}
+    if fmt.Sprint(expLog) != fmt.Sprint(log) {
+        t.Errorf("log mismatch %q != %q.", expLog, log)
+    }
+    expEvents := []string{"dispatch records.other false",
+        "dispatch records.other true", "program 2 6 true"}
+    if fmt.Sprint(expEvents) != fmt.Sprint(metrics.events) {
+        t.Errorf("events mismatch %q != %q.", expEvents, metrics.events)
+    }
+}
@@ -0,0 +1,209 @@
+package vesupro
+
+import (
+    "./apidistiller"
+    "bytes"
+    "container/list"
+    "context"
+    "sync"
+    "time"
+)
+
+// ResultCache caches the JSON results of definitions whose method calls are
+// all cacheable. Entries are keyed on the canonical form of the receiver
+// name and the chain of method calls including their arguments, so
+// cacheable methods must not depend on the caller.
+//
+// As with CostRegistry, only the receiver type of the first call is known
+// before execution. A later call is cacheable only if an added API describes
+// its method and the method is cacheable on every type of the added APIs
+// declaring a method of that name. Methods marked by SetCacheable alone are
+// cacheable as first calls only, as nothing describes the other types.
+type ResultCache struct {
+    // DefaultTTL is used for cacheable methods without TTL.
+    DefaultTTL time.Duration
+    // Now returns the current time. It may be replaced in tests.
+    Now func() time.Time
+
+    mutex sync.Mutex
+    size int
+    entries map[string]*list.Element
+    lru *list.List // front is most recently used
+
+    ttls map[string]map[string]time.Duration // type -> method -> ttl
+    described map[string]bool // methods of the added APIs
+    uncacheable map[string]bool // methods not cacheable on some type
+}
+
+type cacheEntry struct {
+    key string
+    value []byte
+    expires time.Time
+}
+
+// NewResultCache creates a cache holding at most size entries.
+func NewResultCache(size int, defaultTTL time.Duration) *ResultCache {
+    return &ResultCache{
+        DefaultTTL: defaultTTL,
+        Now: time.Now,
+        size: size,
+        entries: make(map[string]*list.Element),
+        lru: list.New(),
+        ttls: make(map[string]map[string]time.Duration),
+        described: make(map[string]bool),
+        uncacheable: make(map[string]bool),
+    }
+}
+
+// SetCacheable marks method on receivers of type typeName as cacheable. A
+// zero ttl selects the DefaultTTL.
+func (c *ResultCache) SetCacheable(typeName string, method string,
+ttl time.Duration) {
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+
+    methods, found := c.ttls[typeName]
+    if !found {
+        methods = make(map[string]time.Duration)
+        c.ttls[typeName] = methods
+    }
+    methods[method] = ttl
+}
+
+// AddAPI marks the cacheable methods of api. Methods of the same name which
+// are not cacheable prevent caching of chained calls to that name.
+func (c *ResultCache) AddAPI(api *apidistiller.API) {
+    for typeName, methods := range api.Methods {
+        for _, method := range methods {
+            c.mutex.Lock()
+            c.described[method.WireName()] = true
+            if !method.Cacheable {
+                c.uncacheable[method.WireName()] = true
+            }
+            c.mutex.Unlock()
+            if !method.Cacheable { continue }
+            c.SetCacheable(typeName, method.WireName(), method.CacheTTL)
+        }
+    }
+}
+
+// ttl returns the time to live of the results of def, false if def is not
+// cacheable.
+func (c *ResultCache) ttl(def *Definition,
+symTable map[string]VesuproObject) (time.Duration, bool) {
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+
+    typeName := QualifiedTypeName(symTable[def.ReceiverName])
+    if _, found := c.ttls[typeName]; !found {
+        typeName = TypeName(symTable[def.ReceiverName])
+    }
+    minTTL := time.Duration(-1)
+    for i, call := range def.MethodCalls {
+        ttl, found := time.Duration(0), false
+        if i == 0 {
+            ttl, found = c.ttls[typeName][call.Name]
+        } else if c.described[call.Name] && !c.uncacheable[call.Name] {
+            // use the shortest ttl over all types
+            for _, methods := range c.ttls {
+                if t, ok := methods[call.Name]; ok && (!found || t < ttl) {
+                    ttl, found = t, true
+                }
+            }
+        }
+        if !found { return 0, false }
+        if ttl == 0 {
+            ttl = c.DefaultTTL
+        }
+        if minTTL < 0 || ttl < minTTL {
+            minTTL = ttl
+        }
+    }
+    return minTTL, minTTL > 0
+}
+
+// cacheKey returns the canonical form of def without its target.
+func cacheKey(def *Definition) (string, error) {
+    buf := &bytes.Buffer{}
+    buf.WriteString(def.ReceiverName)
+    for _, call := range def.MethodCalls {
+        if err := printMethodCall(buf, call); err != nil { return "", err }
+    }
+    return buf.String(), nil
+}
+
+// Get returns the unexpired value stored for key.
+func (c *ResultCache) Get(key string) ([]byte, bool) {
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+
+    elem, found := c.entries[key]
+    if !found { return nil, false }
+    entry := elem.Value.(*cacheEntry)
+    if !c.Now().Before(entry.expires) {
+        c.lru.Remove(elem)
+        delete(c.entries, key)
+        return nil, false
+    }
+    c.lru.MoveToFront(elem)
+    return entry.value, true
+}
+
+// Put stores value for key, evicting the least recently used entry if the
+// cache is full.
+func (c *ResultCache) Put(key string, value []byte, ttl time.Duration) {
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+
+    if c.size <= 0 { return }
+    entry := &cacheEntry{key: key, value: value, expires: c.Now().Add(ttl)}
+    if elem, found := c.entries[key]; found {
+        elem.Value = entry
+        c.lru.MoveToFront(elem)
+        return
+    }
+    for c.lru.Len() >= c.size {
+        oldest := c.lru.Back()
+        c.lru.Remove(oldest)
+        delete(c.entries, oldest.Value.(*cacheEntry).key)
+    }
+    c.entries[key] = c.lru.PushFront(entry)
+}
+
+// Len returns the number of entries, including expired ones.
+func (c *ResultCache) Len() int {
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+    return c.lru.Len()
+}
+
+// requestCache deduplicates cacheable definitions within one program.
+type requestCache map[string]VesuproObject
+
+// evaluateCached evaluates def using the evaluator's cache. Results of
+// cacheable definitions are returned as RawJSON.
+func (e *Evaluator) evaluateCached(ctx context.Context, state *evalState,
+def *Definition) (VesuproObject, error) {
+    ttl, cacheable := e.Cache.ttl(def, e.SymTable)
+    if !cacheable {
+        return e.evaluateDefinition(ctx, state, def)
+    }
+    key, err := cacheKey(def)
+    if err != nil { return nil, err }
+
+    if obj, found := state.seen[key]; found {
+        return obj, nil
+    }
+    if value, found := e.Cache.Get(key); found {
+        state.seen[key] = RawJSON(value)
+        return state.seen[key], nil
+    }
+
+    obj, err := e.evaluateDefinition(ctx, state, def)
+    if err != nil { return nil, err }
+    value, err := obj.MarshalJSON()
+    if err != nil { return nil, err }
+    e.Cache.Put(key, value, ttl)
+    state.seen[key] = RawJSON(value)
+    return state.seen[key], nil
+}
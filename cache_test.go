@@ -0,0 +1,144 @@
+package vesupro_test
+
+import (
+    "./"
+    "./apidistiller"
+    "testing"
+    "bytes"
+    "fmt"
+    "time"
+)
+
+type Preferences struct {
+    dispatched int
+}
+
+type setting struct {
+    value string
+}
+
+func (s *Preferences) Dispatch(mc *vesupro.MethodCall) (vesupro.VesuproObject, error) {
+    s.dispatched++
+    return &setting{fmt.Sprintf("%s#%d", mc.Name, s.dispatched)}, nil
+}
+
+func (s *Preferences) MarshalJSON() ([]byte, error) {
+    return []byte(`"settings"`), nil
+}
+
+func (s *setting) Dispatch(mc *vesupro.MethodCall) (vesupro.VesuproObject, error) {
+    return &setting{s.value + "." + mc.Name}, nil
+}
+
+func (s *setting) MarshalJSON() ([]byte, error) {
+    return []byte(fmt.Sprintf("%q", s.value)), nil
+}
+
+func TestEvaluator_Cache(t *testing.T) {
+    now := time.Unix(0, 0)
+    api := apidistiller.NewAPI("settings")
+    api.Methods["Preferences"] = []*apidistiller.Method{
+        &apidistiller.Method{Name: "Get", Cacheable: true},
+        &apidistiller.Method{Name: "Fresh", Cacheable: true,
+            CacheTTL: time.Second},
+        &apidistiller.Method{Name: "Random"},
+    }
+    api.Methods["setting"] = []*apidistiller.Method{
+        &apidistiller.Method{Name: "Upper", Cacheable: true},
+        &apidistiller.Method{Name: "Random"},
+    }
+
+    cache := vesupro.NewResultCache(2, time.Minute)
+    cache.Now = func() time.Time { return now }
+    cache.AddAPI(api)
+
+    settings := &Preferences{}
+    ev := vesupro.NewEvaluator(map[string]vesupro.VesuproObject{
+        "settings": settings,
+    })
+    ev.Cache = cache
+
+    tests := []struct {
+        in string
+        advance time.Duration
+        out string
+        dispatched int
+    }{
+        // deduplicated within the program
+        {in: `a := settings.get("theme"); b := settings.get( "theme" );`,
+        out: `{"a":"get#1",` + "\n" + `"b":"get#1"}`, dispatched: 1},
+        // cached across programs
+        {in: `a := settings.get("theme");`,
+        out: `{"a":"get#1"}`, dispatched: 0},
+        // different arguments, different entry
+        {in: `a := settings.get("font").upper();`,
+        out: `{"a":"get#2.upper"}`, dispatched: 1},
+        // not cacheable
+        {in: `a := settings.random(); b := settings.random();`,
+        out: `{"a":"random#3",` + "\n" + `"b":"random#4"}`, dispatched: 2},
+        {in: `a := settings.get("x").random();`,
+        out: `{"a":"get#5.random"}`, dispatched: 1},
+        // expired after the chain's shortest ttl
+        {in: `a := settings.fresh().upper();`,
+        out: `{"a":"fresh#6.upper"}`, dispatched: 1},
+        {in: `a := settings.fresh().upper();`, advance: 999 * time.Millisecond,
+        out: `{"a":"fresh#6.upper"}`, dispatched: 0},
+        {in: `a := settings.fresh().upper();`, advance: time.Millisecond,
+        out: `{"a":"fresh#7.upper"}`, dispatched: 1},
+        // the size bound evicted the least recently used theme entry
+        {in: `a := settings.get("theme");`,
+        out: `{"a":"get#8"}`, dispatched: 1},
+    }
+
+    for i, tt := range tests {
+        now = now.Add(tt.advance)
+        before := settings.dispatched
+        out := &bytes.Buffer{}
+        err := ev.Evaluate(out, bytes.NewBufferString(tt.in))
+        if err != nil {
+            t.Errorf("%d. error: %q", i, err)
+        } else if tt.out != out.String() {
+            t.Errorf("%d. in/out mismatch %q != %q.", i, tt.out, out.String())
+        } else if settings.dispatched-before != tt.dispatched {
+            t.Errorf("%d. dispatched %d times, expected %d.", i,
+            settings.dispatched-before, tt.dispatched)
+        }
+    }
+    if cache.Len() != 2 {
+        t.Errorf("cache holds %d entries.", cache.Len())
+    }
+}
+
+func TestEvaluator_CacheWithoutAPI(t *testing.T) {
+    cache := vesupro.NewResultCache(8, time.Minute)
+    cache.SetCacheable("Preferences", "get", 0)
+    // a method of the same name on an unrelated type
+    cache.SetCacheable("Document", "upper", 0)
+
+    settings := &Preferences{}
+    ev := vesupro.NewEvaluator(map[string]vesupro.VesuproObject{
+        "settings": settings,
+    })
+    ev.Cache = cache
+
+    tests := []struct {
+        in string
+        out string
+    }{
+        {in: `a := settings.get("x");`, out: `{"a":"get#1"}`},
+        {in: `a := settings.get("x");`, out: `{"a":"get#1"}`},
+        // the type of the chained receiver is unknown
+        {in: `a := settings.get("x").upper();`, out: `{"a":"get#2.upper"}`},
+        {in: `a := settings.get("x").upper();`, out: `{"a":"get#3.upper"}`},
+    }
+
+    for i, tt := range tests {
+        out := &bytes.Buffer{}
+        err := ev.Evaluate(out, bytes.NewBufferString(tt.in))
+        if err != nil {
+            t.Errorf("%d. error: %q", i, err)
+        } else if tt.out != out.String() {
+            t.Errorf("%d. in/out mismatch %q != %q.", i, tt.out, out.String())
+        }
+    }
+}
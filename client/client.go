@@ -0,0 +1,333 @@
+// Package client provides a type-safe builder for vesupro programs and a
+// small HTTP client which submits them and decodes the results.
+package client
+
+import (
+    ".."
+    "bytes"
+    "context"
+    "encoding"
+    "encoding/json"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "math"
+    "net/http"
+    "reflect"
+    "strconv"
+)
+
+// Program collects definitions and the destinations their results are
+// decoded into.
+type Program struct {
+    defs []*vesupro.Definition
+    dests map[string]interface{}
+    err error
+    cost int
+}
+
+// Chain is a definition under construction. Every call to Call appends a
+// method call to the chain.
+type Chain struct {
+    p *Program
+    def *vesupro.Definition
+}
+
+// NewProgram creates an empty program.
+func NewProgram() *Program {
+    return &Program{
+        defs: make([]*vesupro.Definition, 0, 2),
+        dests: make(map[string]interface{}),
+        cost: -1,
+    }
+}
+
+// Define starts the definition `target := receiver...`. The definition is
+// only valid once at least one method has been called on the returned chain.
+func (p *Program) Define(target string, receiver string) *Chain {
+    def := vesupro.NewDefinition(target, receiver,
+        make([]*vesupro.MethodCall, 0, 2))
+    c := &Chain{p: p, def: def}
+
+    if !isIdent(target) {
+        p.setErr(fmt.Errorf("Invalid target name %q.", target))
+    } else if !isIdent(receiver) {
+        p.setErr(fmt.Errorf("Invalid receiver name %q.", receiver))
+    } else if _, dup := p.dests[target]; dup {
+        p.setErr(fmt.Errorf("Target %q defined twice.", target))
+    }
+
+    p.dests[target] = nil
+    p.defs = append(p.defs, def)
+    return c
+}
+
+// Call appends the method call `.method(args...)` to the chain. Arguments
+// are converted according to their go type: integers become INT, floats
+// become FLOAT, strings become STRING, booleans become TRUE or FALSE and
+// structs, maps and pointers to structs are encoded as JSON objects, slices
+// as JSON arrays. Use Named for named arguments.
+func (c *Chain) Call(method string, args ...interface{}) *Chain {
+    if !isIdent(method) {
+        c.p.setErr(fmt.Errorf("Invalid method name %q.", method))
+    }
+    call := vesupro.NewMethodCall(method)
+    call.Arguments = make([]*vesupro.ArgumentToken, 0, len(args))
+    for i, arg := range args {
+        tok, err := NewArgument(arg)
+        if err != nil {
+            c.p.setErr(fmt.Errorf("Argument %d of %s.%s: %s", i,
+                c.def.TargetName, method, err))
+            continue
+        }
+        if tok.Name == "" && len(call.Arguments) > 0 &&
+            call.Arguments[len(call.Arguments)-1].Name != "" {
+            c.p.setErr(fmt.Errorf("Argument %d of %s.%s follows a named "+
+                "argument.", i, c.def.TargetName, method))
+        }
+        call.Arguments = append(call.Arguments, tok)
+    }
+    c.def.MethodCalls = append(c.def.MethodCalls, call)
+    return c
+}
+
+// Into sets the destination the result of the definition is decoded into.
+// dest has to be a pointer, as required by json.Unmarshal.
+func (c *Chain) Into(dest interface{}) *Chain {
+    if v := reflect.ValueOf(dest); v.Kind() != reflect.Ptr || v.IsNil() {
+        c.p.setErr(fmt.Errorf(
+            "Destination of target %q is not a non-nil pointer.",
+            c.def.TargetName))
+        return c
+    }
+    c.p.dests[c.def.TargetName] = dest
+    return c
+}
+
+// Cost returns the cost the server computed for the program during its last
+// execution, or -1 if the server did not report a cost.
+func (p *Program) Cost() int {
+    return p.cost
+}
+
+// Definitions returns the definitions built so far.
+func (p *Program) Definitions() []*vesupro.Definition {
+    return p.defs
+}
+
+// Err returns the first error encountered while building the program.
+func (p *Program) Err() error {
+    if p.err != nil { return p.err }
+    for _, def := range p.defs {
+        if len(def.MethodCalls) == 0 {
+            return fmt.Errorf("Target %q has no method calls.",
+                def.TargetName)
+        }
+    }
+    return nil
+}
+
+func (p *Program) setErr(err error) {
+    if p.err == nil {
+        p.err = err
+    }
+}
+
+// WriteTo writes the canonical program text to w.
+func (p *Program) WriteTo(w io.Writer) (int64, error) {
+    if err := p.Err(); err != nil { return 0, err }
+
+    buf := &bytes.Buffer{}
+    if err := vesupro.Print(buf, p.defs); err != nil { return 0, err }
+    return buf.WriteTo(w)
+}
+
+// String returns the canonical program text.
+func (p *Program) String() string {
+    buf := &bytes.Buffer{}
+    p.WriteTo(buf)
+    return buf.String()
+}
+
+// Decode decodes an evaluation result of the form {"target": ...} into the
+// destinations registered with Into.
+func (p *Program) Decode(r io.Reader) error {
+    results := make(map[string]json.RawMessage)
+    if err := json.NewDecoder(r).Decode(&results); err != nil {
+        return fmt.Errorf("Decoding response failed: %s", err)
+    }
+
+    for _, def := range p.defs {
+        raw, found := results[def.TargetName]
+        if !found {
+            return fmt.Errorf("Target %q missing in response.",
+                def.TargetName)
+        }
+        dest := p.dests[def.TargetName]
+        if dest == nil { continue }
+        if err := json.Unmarshal(raw, dest); err != nil {
+            return fmt.Errorf("Decoding target %q failed: %s",
+                def.TargetName, err)
+        }
+    }
+    return nil
+}
+
+// NamedArgument is an argument passed by name, e.g. `limit: 10`.
+type NamedArgument struct {
+    Name string
+    Value interface{}
+}
+
+// Named returns the argument `name: v`. Named arguments have to follow all
+// positional arguments of a call.
+func Named(name string, v interface{}) NamedArgument {
+    return NamedArgument{Name: name, Value: v}
+}
+
+// NewArgument converts a go value into an argument token. A NamedArgument
+// is converted into a token carrying its name, an encoding.TextMarshaler
+// such as time.Time into a string.
+func NewArgument(v interface{}) (*vesupro.ArgumentToken, error) {
+    if named, ok := v.(NamedArgument); ok {
+        if !isIdent(named.Name) {
+            return nil, fmt.Errorf("Invalid argument name %q.", named.Name)
+        }
+        tok, err := NewArgument(named.Value)
+        if err != nil { return nil, err }
+        tok.Name = named.Name
+        return tok, nil
+    }
+
+    if m, ok := v.(encoding.TextMarshaler); ok {
+        text, err := m.MarshalText()
+        if err != nil { return nil, err }
+        return NewArgument(string(text))
+    }
+
+    rv := reflect.ValueOf(v)
+    switch rv.Kind() {
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+        reflect.Int64:
+        return newToken(vesupro.INT, strconv.FormatInt(rv.Int(), 10)), nil
+    case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+        reflect.Uint64:
+        return newToken(vesupro.INT, strconv.FormatUint(rv.Uint(), 10)), nil
+    case reflect.Float32, reflect.Float64:
+        f := rv.Float()
+        if math.IsNaN(f) || math.IsInf(f, 0) {
+            return nil, fmt.Errorf("Cannot encode %v as FLOAT.", f)
+        }
+        s := strconv.FormatFloat(f, 'g', -1, rv.Type().Bits())
+        if !bytes.ContainsAny([]byte(s), ".eE") {
+            s += ".0"
+        }
+        return newToken(vesupro.FLOAT, s), nil
+    case reflect.Bool:
+        if rv.Bool() {
+            return newToken(vesupro.TRUE, "true"), nil
+        }
+        return newToken(vesupro.FALSE, "false"), nil
+    case reflect.String:
+        quoted, err := json.Marshal(rv.String())
+        if err != nil { return nil, err }
+        return &vesupro.ArgumentToken{
+            TokenType: vesupro.STRING, TokenContent: quoted}, nil
+    case reflect.Struct, reflect.Map, reflect.Ptr:
+        if rv.Kind() == reflect.Ptr && rv.IsNil() {
+            return nil, fmt.Errorf("Cannot encode nil pointer.")
+        }
+        content, err := json.Marshal(v)
+        if err != nil { return nil, err }
+        if len(content) == 0 || content[0] != '{' {
+            return nil, fmt.Errorf(
+                "Type %T does not encode to a JSON object.", v)
+        }
+        return &vesupro.ArgumentToken{
+            TokenType: vesupro.JSON, TokenContent: content}, nil
+    case reflect.Slice, reflect.Array:
+        content, err := json.Marshal(v)
+        if err != nil { return nil, err }
+        if len(content) == 0 || content[0] != '[' {
+            return nil, fmt.Errorf(
+                "Type %T does not encode to a JSON array.", v)
+        }
+        return &vesupro.ArgumentToken{
+            TokenType: vesupro.JSON, TokenContent: content}, nil
+    }
+    return nil, fmt.Errorf("Unsupported argument type %T.", v)
+}
+
+func newToken(tok vesupro.Token, content string) *vesupro.ArgumentToken {
+    return &vesupro.ArgumentToken{
+        TokenType: tok, TokenContent: []byte(content)}
+}
+
+func isIdent(s string) bool {
+    if len(s) == 0 { return false }
+    for i, ch := range s {
+        switch {
+        case 'a' <= ch && ch <= 'z', 'A' <= ch && ch <= 'Z', ch == '_':
+        case i > 0 && '0' <= ch && ch <= '9':
+        default:
+            return false
+        }
+    }
+    switch s {
+    case "true", "false", "null":
+        return false
+    }
+    return true
+}
+
+// # HTTP Client #
+
+// Client submits programs to a vesupro endpoint.
+type Client struct {
+    URL string
+    HTTPClient *http.Client
+}
+
+// New creates a client for the endpoint at url using http.DefaultClient.
+func New(url string) *Client {
+    return &Client{URL: url, HTTPClient: http.DefaultClient}
+}
+
+// Execute sends the program and decodes the results into the destinations
+// of the program.
+func (c *Client) Execute(p *Program) error {
+    return c.ExecuteContext(context.Background(), p)
+}
+
+// ExecuteContext is like Execute but carries ctx with the request.
+func (c *Client) ExecuteContext(ctx context.Context, p *Program) error {
+    body := &bytes.Buffer{}
+    if _, err := p.WriteTo(body); err != nil { return err }
+
+    req, err := http.NewRequest("POST", c.URL, body)
+    if err != nil { return err }
+    req = req.WithContext(ctx)
+    req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+    req.Header.Set("Accept", "application/json")
+
+    httpClient := c.HTTPClient
+    if httpClient == nil {
+        httpClient = http.DefaultClient
+    }
+    resp, err := httpClient.Do(req)
+    if err != nil { return err }
+    defer resp.Body.Close()
+
+    p.cost = -1
+    if cost, err := strconv.Atoi(resp.Header.Get(vesupro.CostHeader));
+    err == nil {
+        p.cost = cost
+    }
+
+    if resp.StatusCode != http.StatusOK {
+        msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+        return fmt.Errorf("Request failed with status %s: %s", resp.Status,
+            bytes.TrimSpace(msg))
+    }
+    return p.Decode(resp.Body)
+}
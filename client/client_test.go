@@ -0,0 +1,149 @@
+package client_test
+
+import (
+    "./"
+    ".."
+    "testing"
+    "encoding/json"
+    "net/http/httptest"
+    "time"
+)
+
+type echoObject struct {
+    Calls []string
+}
+
+func (e *echoObject) Dispatch(mc *vesupro.MethodCall) (vesupro.VesuproObject, error) {
+    call := mc.Name + "("
+    for i, arg := range mc.Arguments {
+        if i > 0 {
+            call += ","
+        }
+        call += string(arg.TokenContent)
+    }
+    return &echoObject{Calls: append(e.Calls, call+")")}, nil
+}
+
+func (e *echoObject) MarshalJSON() ([]byte, error) {
+    return json.Marshal(e.Calls)
+}
+
+type filter struct {
+    Name string `json:"name"`
+}
+
+func TestProgram_String(t *testing.T) {
+    tests := []struct {
+        build func(p *client.Program)
+        out string
+    }{
+        {build: func(p *client.Program) {
+            p.Define("v1", "mockObject").Call("foo", 0.1).Call("bar", true)
+        }, out: "v1 := mockObject.foo(0.1).bar(true);\n"},
+
+        {build: func(p *client.Program) {
+            p.Define("v1", "users").Call("get", int64(1), uint8(2), 3.0)
+            p.Define("v2", "users").Call("find", "a \"quoted\"\nname",
+                &filter{Name: "x"})
+        }, out: "v1 := users.get(1, 2, 3.0);\n" +
+            `v2 := users.find("a \"quoted\"\nname", {"name":"x"});` + "\n"},
+
+        {build: func(p *client.Program) {
+            p.Define("v1", "users").Call("getAll", []int{1, 2},
+                []*filter{&filter{Name: "x"}})
+        }, out: `v1 := users.getAll([1,2], [{"name":"x"}]);` + "\n"},
+
+        {build: func(p *client.Program) {
+            p.Define("v1", "search").Call("run", "x",
+                client.Named("limit", 10), client.Named("exact", true))
+        }, out: "v1 := search.run(\"x\", limit: 10, exact: true);\n"},
+
+        {build: func(p *client.Program) {
+            p.Define("v1", "events").Call("since",
+                time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC),
+                map[string]string{"lang": "en"})
+        }, out: `v1 := events.since("2017-03-01T12:00:00Z", {"lang":"en"});` +
+            "\n"},
+    }
+
+    for i, tt := range tests {
+        p := client.NewProgram()
+        tt.build(p)
+        if err := p.Err(); err != nil {
+            t.Errorf("%d. error: %q", i, err)
+        } else if p.String() != tt.out {
+            t.Errorf("%d. in/out mismatch %q != %q.", i, tt.out, p.String())
+        }
+    }
+}
+
+func TestProgram_Err(t *testing.T) {
+    tests := []func(p *client.Program){
+        func(p *client.Program) { p.Define("1v", "users").Call("get") },
+        func(p *client.Program) { p.Define("v", "true").Call("get") },
+        func(p *client.Program) { p.Define("v", "users").Call("g-t") },
+        func(p *client.Program) { p.Define("v", "users") },
+        func(p *client.Program) {
+            p.Define("v", "users").Call("get")
+            p.Define("v", "users").Call("get")
+        },
+        func(p *client.Program) { p.Define("v", "users").Call("get", nil) },
+        func(p *client.Program) {
+            p.Define("v", "users").Call("get", []byte{1})
+        },
+        func(p *client.Program) {
+            p.Define("v", "users").Call("get", []int(nil))
+        },
+        func(p *client.Program) {
+            p.Define("v", "users").Call("get", client.Named("1d", 1))
+        },
+        func(p *client.Program) {
+            p.Define("v", "users").Call("get", client.Named("id", 1), 2)
+        },
+        func(p *client.Program) {
+            var dest struct{}
+            p.Define("v", "users").Call("get").Into(dest)
+        },
+    }
+
+    for i, build := range tests {
+        p := client.NewProgram()
+        build(p)
+        if p.Err() == nil {
+            t.Errorf("%d. expected error for %q.", i, p.String())
+        }
+    }
+}
+
+func TestClient_Execute(t *testing.T) {
+    h := vesupro.NewHandler(map[string]vesupro.VesuproObject{
+        "users": &echoObject{},
+    })
+    h.Evaluator.Costs = vesupro.NewCostRegistry(1)
+    server := httptest.NewServer(h)
+    defer server.Close()
+
+    var v1, v2 []string
+    p := client.NewProgram()
+    p.Define("v1", "users").Call("get", 1).Call("name").Into(&v1)
+    p.Define("v2", "users").Call("find", "x").Into(&v2)
+
+    if err := client.New(server.URL).Execute(p); err != nil {
+        t.Fatalf("error: %q", err)
+    }
+    if len(v1) != 2 || v1[0] != "get(1)" || v1[1] != "name()" {
+        t.Errorf("unexpected v1 %q.", v1)
+    }
+    if len(v2) != 1 || v2[0] != `find("x")` {
+        t.Errorf("unexpected v2 %q.", v2)
+    }
+    if p.Cost() != 3 {
+        t.Errorf("unexpected cost %d.", p.Cost())
+    }
+
+    p = client.NewProgram()
+    p.Define("v1", "unknown").Call("get")
+    if err := client.New(server.URL).Execute(p); err == nil {
+        t.Errorf("expected error for unknown receiver.")
+    }
+}
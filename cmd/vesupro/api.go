@@ -0,0 +1,111 @@
+package main
+
+import (
+    "../../apidistiller"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "io/ioutil"
+    "os"
+)
+
+var apiCommand = &command{
+    name: "api",
+    usage: "print the API of a go package as JSON snapshot",
+    run: runAPI,
+}
+
+var apidiffCommand = &command{
+    name: "apidiff",
+    usage: "compare two API snapshots, failing on breaking changes",
+    run: runAPIDiff,
+}
+
+var genCommand = &command{
+    name: "gen",
+    usage: "generate argument decoders for API snapshots",
+    run: runGen,
+}
+
+func runAPI(args []string) error {
+    flags := flag.NewFlagSet("api", flag.ContinueOnError)
+    importPath := flags.String("import", "",
+        "qualify type names by the import path of the package")
+    if err := flags.Parse(args); err != nil { return err }
+    if flags.NArg() != 1 {
+        return fmt.Errorf("Expected the directory of a go package.")
+    }
+
+    api, err := distillDir(flags.Arg(0))
+    if err != nil { return err }
+    if *importPath != "" {
+        api = api.Qualify(*importPath)
+    }
+    out, err := json.MarshalIndent(api, "", "    ")
+    if err != nil { return err }
+    _, err = fmt.Fprintf(os.Stdout, "%s\n", out)
+    return err
+}
+
+func readAPI(path string) (*apidistiller.API, error) {
+    in, err := ioutil.ReadFile(path)
+    if err != nil { return nil, err }
+    api := &apidistiller.API{}
+    if err := json.Unmarshal(in, api); err != nil {
+        return nil, fmt.Errorf("%s: %s", path, err)
+    }
+    return api, nil
+}
+
+func runAPIDiff(args []string) error {
+    flags := flag.NewFlagSet("apidiff", flag.ContinueOnError)
+    quiet := flags.Bool("q", false, "report breaking changes only")
+    if err := flags.Parse(args); err != nil { return err }
+    if flags.NArg() != 2 {
+        return fmt.Errorf("Expected an old and a new API snapshot.")
+    }
+
+    from, err := readAPI(flags.Arg(0))
+    if err != nil { return err }
+    to, err := readAPI(flags.Arg(1))
+    if err != nil { return err }
+
+    changes := apidistiller.Diff(from, to)
+    for _, change := range changes {
+        if *quiet && !change.Breaking { continue }
+        fmt.Fprintln(os.Stdout, change)
+    }
+    if n := len(apidistiller.Breaking(changes)); n > 0 {
+        return fmt.Errorf("%d breaking changes.", n)
+    }
+    return nil
+}
+
+func runGen(args []string) error {
+    flags := flag.NewFlagSet("gen", flag.ContinueOnError)
+    pkgName := flags.String("pkg", "", "package name of the generated code")
+    vesuproPath := flags.String("vesupro", "github.com/d-s-d/vesupro",
+        "import path of package vesupro")
+    if err := flags.Parse(args); err != nil { return err }
+    if *pkgName == "" || flags.NArg() == 0 {
+        return fmt.Errorf("Expected -pkg and at least one API snapshot, " +
+            "qualified by -import of vesupro api if there are several.")
+    }
+
+    var api *apidistiller.API
+    for _, path := range flags.Args() {
+        snapshot, err := readAPI(path)
+        if err != nil { return err }
+        if flags.NArg() == 1 {
+            api = snapshot
+            break
+        }
+        if api == nil {
+            api = apidistiller.NewAPI("")
+        }
+        if err := api.Merge(snapshot); err != nil {
+            return fmt.Errorf("%s: %s", path, err)
+        }
+    }
+    return api.GenerateDecoders(os.Stdout, *pkgName, *vesuproPath)
+}
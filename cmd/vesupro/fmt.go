@@ -0,0 +1,57 @@
+package main
+
+import (
+    "../.."
+    "bytes"
+    "flag"
+    "fmt"
+    "io/ioutil"
+    "os"
+)
+
+var fmtCommand = &command{
+    name: "fmt",
+    usage: "reformat query files (stdin if no files are given)",
+    run: runFmt,
+}
+
+func runFmt(args []string) error {
+    flags := flag.NewFlagSet("fmt", flag.ContinueOnError)
+    write := flags.Bool("w", false,
+        "write result to the source file instead of stdout")
+    width := flags.Int("width", 0,
+        "wrap method chains longer than width (0 disables wrapping)")
+    if err := flags.Parse(args); err != nil { return err }
+
+    p := &vesupro.Printer{LineWidth: *width}
+
+    if flags.NArg() == 0 {
+        if *write {
+            return fmt.Errorf("Cannot use -w with standard input.")
+        }
+        in, err := ioutil.ReadAll(os.Stdin)
+        if err != nil { return err }
+        return p.Format(os.Stdout, bytes.NewBuffer(in))
+    }
+
+    for _, path := range flags.Args() {
+        in, err := ioutil.ReadFile(path)
+        if err != nil { return err }
+
+        out := &bytes.Buffer{}
+        if err := p.Format(out, bytes.NewBuffer(in)); err != nil {
+            return fmt.Errorf("%s: %s", path, err)
+        }
+
+        if !*write {
+            if _, err := out.WriteTo(os.Stdout); err != nil { return err }
+            continue
+        }
+        if bytes.Equal(in, out.Bytes()) { continue }
+        info, err := os.Stat(path)
+        if err != nil { return err }
+        err = ioutil.WriteFile(path, out.Bytes(), info.Mode().Perm())
+        if err != nil { return err }
+    }
+    return nil
+}
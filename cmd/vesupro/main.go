@@ -0,0 +1,53 @@
+// Command vesupro bundles the tools for working with vesupro programs.
+//
+// Usage:
+//
+//     vesupro <command> [arguments]
+package main
+
+import (
+    "fmt"
+    "os"
+)
+
+type command struct {
+    name string
+    usage string
+    run func(args []string) error
+}
+
+var commands = []*command{
+    fmtCommand,
+    replCommand,
+    apiCommand,
+    apidiffCommand,
+    genCommand,
+}
+
+func usage() {
+    fmt.Fprintf(os.Stderr, "Usage: vesupro <command> [arguments]\n\n")
+    fmt.Fprintf(os.Stderr, "Commands:\n")
+    for _, cmd := range commands {
+        fmt.Fprintf(os.Stderr, "    %-8s %s\n", cmd.name, cmd.usage)
+    }
+}
+
+func main() {
+    if len(os.Args) < 2 {
+        usage()
+        os.Exit(2)
+    }
+
+    for _, cmd := range commands {
+        if cmd.name != os.Args[1] { continue }
+        if err := cmd.run(os.Args[2:]); err != nil {
+            fmt.Fprintf(os.Stderr, "vesupro %s: %s\n", cmd.name, err)
+            os.Exit(1)
+        }
+        return
+    }
+
+    fmt.Fprintf(os.Stderr, "vesupro: unknown command %q\n\n", os.Args[1])
+    usage()
+    os.Exit(2)
+}
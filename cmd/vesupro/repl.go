@@ -0,0 +1,91 @@
+package main
+
+import (
+    "../../apidistiller"
+    "../../repl"
+    "flag"
+    "fmt"
+    "go/ast"
+    "go/parser"
+    "go/token"
+    "net"
+    "os"
+    "sort"
+    "strings"
+)
+
+var replCommand = &command{
+    name: "repl",
+    usage: "evaluate definitions interactively against an endpoint or a " +
+        "session server",
+    run: runRepl,
+}
+
+// receiverFlags collects -receiver name=Type flags.
+type receiverFlags map[string]string
+
+func (r receiverFlags) String() string { return fmt.Sprint(map[string]string(r)) }
+
+func (r receiverFlags) Set(value string) error {
+    parts := strings.SplitN(value, "=", 2)
+    if len(parts) != 2 || parts[0] == "" {
+        return fmt.Errorf("Expected name=Type, got %q.", value)
+    }
+    r[parts[0]] = parts[1]
+    return nil
+}
+
+func runRepl(args []string) error {
+    flags := flag.NewFlagSet("repl", flag.ContinueOnError)
+    url := flags.String("url", "", "URL of the vesupro endpoint")
+    addr := flags.String("addr", "",
+        "TCP address of a vesupro session server, which keeps targets in scope")
+    receivers := receiverFlags{}
+    flags.Var(receivers, "receiver",
+        "receiver offered by the endpoint as name=Type (repeatable)")
+    if err := flags.Parse(args); err != nil { return err }
+
+    if (*url == "") == (*addr == "") {
+        return fmt.Errorf("Expected either -url or -addr.")
+    }
+
+    var ev repl.Evaluator = repl.NewRemoteEvaluator(*url, receivers)
+    if *addr != "" {
+        conn, err := net.Dial("tcp", *addr)
+        if err != nil { return err }
+        defer conn.Close()
+        ev = repl.NewSessionEvaluator(conn, receivers)
+    }
+    return repl.New(ev, nil).Run(os.Stdin, os.Stdout)
+}
+
+// distillDir distills the API of the non-test go package in dir.
+func distillDir(dir string) (*apidistiller.API, error) {
+    fset := token.NewFileSet()
+    pkgs, err := parser.ParseDir(fset, dir, func(info os.FileInfo) bool {
+        return !strings.HasSuffix(info.Name(), "_test.go")
+    }, parser.ParseComments)
+    if err != nil { return nil, err }
+    if len(pkgs) != 1 {
+        return nil, fmt.Errorf("Expected exactly one package in %s, found %d.",
+            dir, len(pkgs))
+    }
+
+    for name, pkg := range pkgs {
+        // distill in a stable order, so that snapshots can be compared
+        fileNames := make([]string, 0, len(pkg.Files))
+        for fileName := range pkg.Files {
+            fileNames = append(fileNames, fileName)
+        }
+        sort.Strings(fileNames)
+        files := make([]*ast.File, len(fileNames))
+        for i, fileName := range fileNames {
+            files[i] = pkg.Files[fileName]
+        }
+
+        api := apidistiller.NewAPI(name)
+        if err := api.DistillFromAstFiles(files); err != nil { return nil, err }
+        return api, nil
+    }
+    return nil, nil
+}
@@ -0,0 +1,162 @@
+package vesupro
+
+import (
+    "./apidistiller"
+    "context"
+    "fmt"
+    "reflect"
+    "strings"
+    "sync"
+)
+
+// CostRegistry assigns costs to methods. Costs are keyed by the go type name
+// of the receiver, qualified or not, and the method name as it appears in
+// programs.
+//
+// Only the receiver of the first call of a definition is known before
+// execution, all subsequent calls are priced with the most expensive method
+// of the same name, regardless of the receiver type.
+type CostRegistry struct {
+    // DefaultCost is the cost of methods without a registered cost.
+    DefaultCost int
+
+    mutex sync.RWMutex
+    costs map[string]map[string]int // type name -> method -> cost
+    maxCosts map[string]int        // method -> max cost over all types
+}
+
+// NewCostRegistry creates an empty registry.
+func NewCostRegistry(defaultCost int) *CostRegistry {
+    return &CostRegistry{
+        DefaultCost: defaultCost,
+        costs: make(map[string]map[string]int),
+        maxCosts: make(map[string]int),
+    }
+}
+
+// Set registers the cost of method on receivers of type typeName.
+func (r *CostRegistry) Set(typeName string, method string, cost int) {
+    r.mutex.Lock()
+    defer r.mutex.Unlock()
+
+    methods, found := r.costs[typeName]
+    if !found {
+        methods = make(map[string]int)
+        r.costs[typeName] = methods
+    }
+    methods[method] = cost
+    if max, found := r.maxCosts[method]; !found || cost > max {
+        r.maxCosts[method] = cost
+    }
+}
+
+// AddAPI registers the costs declared by the export directives of api.
+// Methods without declared cost are skipped.
+func (r *CostRegistry) AddAPI(api *apidistiller.API) {
+    for typeName, methods := range api.Methods {
+        for _, method := range methods {
+            if method.Cost == 0 { continue }
+            r.Set(typeName, method.WireName(), int(method.Cost))
+        }
+    }
+}
+
+// MethodCost returns the cost of method on receivers of type typeName. If
+// typeName is empty, the most expensive method of that name is used.
+func (r *CostRegistry) MethodCost(typeName string, method string) int {
+    r.mutex.RLock()
+    defer r.mutex.RUnlock()
+
+    if typeName != "" {
+        if cost, found := r.costs[typeName][method]; found {
+            return cost
+        }
+    } else if cost, found := r.maxCosts[method]; found {
+        return cost
+    }
+    return r.DefaultCost
+}
+
+// typeName returns the qualified type name of obj if costs are registered
+// for it, the unqualified one otherwise.
+func (r *CostRegistry) typeName(obj interface{}) string {
+    r.mutex.RLock()
+    defer r.mutex.RUnlock()
+    if _, found := r.costs[QualifiedTypeName(obj)]; found {
+        return QualifiedTypeName(obj)
+    }
+    return TypeName(obj)
+}
+
+// ProgramCost computes the cost of defs before they are executed.
+func (r *CostRegistry) ProgramCost(defs []*Definition,
+symTable map[string]VesuproObject) int {
+    total := 0
+    for _, def := range defs {
+        typeName := ""
+        if obj, found := symTable[def.ReceiverName]; found {
+            typeName = r.typeName(obj)
+        }
+        for i, call := range def.MethodCalls {
+            if i > 0 {
+                typeName = ""
+            }
+            total += r.MethodCost(typeName, call.Name)
+        }
+    }
+    return total
+}
+
+// TypeName returns the name of the type of obj as used by the API, i.e.,
+// without pointers and type arguments, e.g. "List" for a *List[int].
+func TypeName(obj interface{}) string {
+    t := reflect.TypeOf(obj)
+    for t != nil && t.Kind() == reflect.Ptr {
+        t = t.Elem()
+    }
+    if t == nil { return "" }
+    name := t.Name()
+    if i := strings.IndexByte(name, '['); i >= 0 {
+        name = name[:i]
+    }
+    return name
+}
+
+// QualifiedTypeName is like TypeName, qualified by the import path of the
+// type, e.g. "example.com/shop.List" for a *shop.List[int]. It is the name
+// used by APIs of several packages, see apidistiller.API.Qualify.
+func QualifiedTypeName(obj interface{}) string {
+    t := reflect.TypeOf(obj)
+    for t != nil && t.Kind() == reflect.Ptr {
+        t = t.Elem()
+    }
+    if t == nil || t.PkgPath() == "" { return TypeName(obj) }
+    return t.PkgPath() + "." + TypeName(obj)
+}
+
+// APITypeName returns the name of the type of obj in api, i.e., the
+// qualified name if api describes it, the unqualified name otherwise.
+func APITypeName(api *apidistiller.API, obj interface{}) string {
+    if api != nil {
+        if _, found := api.Methods[QualifiedTypeName(obj)]; found {
+            return QualifiedTypeName(obj)
+        }
+    }
+    return TypeName(obj)
+}
+
+// BudgetError is returned if the cost of a program exceeds the budget of the
+// caller.
+type BudgetError struct {
+    Cost int
+    Budget int
+}
+
+func (e *BudgetError) Error() string {
+    return fmt.Sprintf("Program cost %d exceeds budget %d.", e.Cost,
+        e.Budget)
+}
+
+// BudgetFunc returns the budget of the caller identified by ctx. A negative
+// budget is unlimited.
+type BudgetFunc func(ctx context.Context) int
@@ -0,0 +1,142 @@
+package vesupro_test
+
+import (
+    "./"
+    "./apidistiller"
+    "testing"
+    "bytes"
+    "context"
+    "go/parser"
+    "go/token"
+    "net/http"
+    "net/http/httptest"
+    "strconv"
+)
+
+type Users struct{ countingObject }
+type Settings struct{ countingObject }
+
+const costSource = `package users
+
+// vesupro: export cost=10
+func (u *Users) Search(query string) {}
+
+// vesupro: export
+func (u *Users) Get(id int) {}
+
+// Search is more expensive on settings.
+// vesupro: export cost=25
+func (s *Settings) Search(query string) {}
+`
+
+func newCostRegistry(t *testing.T) *vesupro.CostRegistry {
+    f, err := parser.ParseFile(token.NewFileSet(), "users.go", costSource,
+        parser.ParseComments)
+    if err != nil { t.Fatal(err) }
+    api := apidistiller.NewAPI("users")
+    if err := api.DistillFromAstFile(f); err != nil { t.Fatal(err) }
+
+    costs := vesupro.NewCostRegistry(1)
+    costs.AddAPI(api)
+    costs.Set("Users", "get", 2)
+    return costs
+}
+
+func TestCostRegistry_ProgramCost(t *testing.T) {
+    costs := newCostRegistry(t)
+    symTable := map[string]vesupro.VesuproObject{
+        "users": &Users{}, "settings": &Settings{},
+    }
+
+    tests := []struct {
+        in string
+        cost int
+    }{
+        {in: `v1 := users.get(1);`, cost: 2},
+        {in: `v1 := users.search("x");`, cost: 10},
+        {in: `v1 := settings.search("x");`, cost: 25},
+        {in: `v1 := settings.get(1);`, cost: 1},
+        // the receiver of chained calls is unknown: use the maximum
+        {in: `v1 := users.get(1).search("x").unknown();`, cost: 2 + 25 + 1},
+        {in: `v1 := users.get(1); v2 := users.get(2);`, cost: 4},
+    }
+
+    for i, tt := range tests {
+        defs, err := vesupro.ParseDefinitions(
+            vesupro.NewTokenizer(bytes.NewBufferString(tt.in)))
+        if err != nil {
+            t.Errorf("%d. error: %q", i, err)
+        } else if cost := costs.ProgramCost(defs, symTable); tt.cost != cost {
+            t.Errorf("%d. cost mismatch %d != %d.", i, tt.cost, cost)
+        }
+    }
+}
+
+type budgetKey struct{}
+
+func TestEvaluator_Budget(t *testing.T) {
+    users := &Users{}
+    ev := vesupro.NewEvaluator(map[string]vesupro.VesuproObject{
+        "users": users,
+    })
+    ev.Costs = newCostRegistry(t)
+    ev.Budget = func(ctx context.Context) int {
+        if budget, ok := ctx.Value(budgetKey{}).(int); ok {
+            return budget
+        }
+        return -1
+    }
+
+    tests := []struct {
+        budget interface{}
+        ok bool
+    }{
+        {budget: nil, ok: true},
+        {budget: 12, ok: true},
+        {budget: 11, ok: false},
+    }
+
+    for i, tt := range tests {
+        ctx := context.WithValue(context.Background(), budgetKey{}, tt.budget)
+        users.dispatched = 0
+        info, err := ev.EvaluateContext(ctx, &bytes.Buffer{},
+            bytes.NewBufferString(`v1 := users.get(1); v2 := users.search("x");`))
+
+        if info == nil || info.Cost != 12 {
+            t.Errorf("%d. unexpected info %v.", i, info)
+        }
+        _, overBudget := err.(*vesupro.BudgetError)
+        switch {
+        case tt.ok && err != nil:
+            t.Errorf("%d. error: %q", i, err)
+        case !tt.ok && !overBudget:
+            t.Errorf("%d. expected BudgetError, got %q", i, err)
+        case !tt.ok && users.dispatched != 0:
+            t.Errorf("%d. dispatched despite exceeded budget.", i)
+        }
+    }
+
+    h := &vesupro.Handler{Evaluator: ev,
+        Encoders: []vesupro.ResultEncoder{vesupro.JSONEncoder{}}}
+    ev.Budget = func(ctx context.Context) int { return 5 }
+    for _, tt := range []struct {
+        in string
+        status int
+        cost int
+    }{
+        {in: `v1 := users.get(1);`, status: http.StatusOK, cost: 2},
+        {in: `v1 := users.search("x");`, status: http.StatusTooManyRequests,
+        cost: 10},
+    } {
+        rec := httptest.NewRecorder()
+        h.ServeHTTP(rec, httptest.NewRequest("POST", "/",
+            bytes.NewBufferString(tt.in)))
+        if rec.Code != tt.status {
+            t.Errorf("%q status mismatch %d != %d.", tt.in, tt.status, rec.Code)
+        }
+        if rec.Header().Get(vesupro.CostHeader) != strconv.Itoa(tt.cost) {
+            t.Errorf("%q cost header mismatch %d != %q.", tt.in, tt.cost,
+            rec.Header().Get(vesupro.CostHeader))
+        }
+    }
+}
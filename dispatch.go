@@ -0,0 +1,37 @@
+package vesupro
+
+import (
+    "context"
+    "fmt"
+)
+
+// ContextDispatcher is implemented by objects whose methods take the context
+// of the request, e.g. `func (u *Users) Get(ctx context.Context, id int64)`.
+// The evaluator calls DispatchContext instead of Dispatch for them.
+type ContextDispatcher interface {
+    VesuproObject
+    DispatchContext(ctx context.Context, c *MethodCall) (VesuproObject, error)
+}
+
+// DispatchError is returned if an object fails to dispatch a method.
+type DispatchError struct {
+    Receiver string // receiver name the definition starts at
+    Method string
+    Err error // error returned by the object
+}
+
+func (e *DispatchError) Error() string {
+    return fmt.Sprintf("%s.%s: %s", e.Receiver, e.Method, e.Err)
+}
+
+// Unwrap returns the error returned by the object.
+func (e *DispatchError) Unwrap() error { return e.Err }
+
+// dispatch dispatches c on obj, passing ctx to a ContextDispatcher.
+func dispatch(ctx context.Context, obj VesuproObject,
+c *MethodCall) (VesuproObject, error) {
+    if dispatcher, ok := obj.(ContextDispatcher); ok {
+        return dispatcher.DispatchContext(ctx, c)
+    }
+    return obj.Dispatch(c)
+}
@@ -0,0 +1,63 @@
+package vesupro_test
+
+import (
+    "./"
+    "testing"
+    "bytes"
+    "context"
+    "errors"
+    "fmt"
+)
+
+// Accounts answers with the account of the caller in its context.
+type Accounts struct{}
+
+type callerKey struct{}
+
+func (a *Accounts) Dispatch(mc *vesupro.MethodCall) (vesupro.VesuproObject, error) {
+    return nil, fmt.Errorf("Dispatch called without context.")
+}
+
+func (a *Accounts) DispatchContext(ctx context.Context,
+mc *vesupro.MethodCall) (vesupro.VesuproObject, error) {
+    caller, _ := ctx.Value(callerKey{}).(string)
+    if mc.Name != "me" || caller == "" {
+        return nil, fmt.Errorf("No account.")
+    }
+    return vesupro.RawJSON(fmt.Sprintf("%q", caller)), nil
+}
+
+func (a *Accounts) MarshalJSON() ([]byte, error) {
+    return []byte(`"accounts"`), nil
+}
+
+func TestEvaluator_DispatchContext(t *testing.T) {
+    ev := vesupro.NewEvaluator(map[string]vesupro.VesuproObject{
+        "accounts": &Accounts{},
+    })
+    ctx := context.WithValue(context.Background(), callerKey{}, "alice")
+
+    out := &bytes.Buffer{}
+    _, err := ev.EvaluateContext(ctx, out,
+        bytes.NewBufferString(`a := accounts.me();`))
+    if err != nil {
+        t.Fatalf("error: %q", err)
+    }
+    if out.String() != `{"a":"alice"}` {
+        t.Errorf("unexpected output %q.", out.String())
+    }
+
+    _, err = ev.EvaluateContext(ctx, &bytes.Buffer{},
+        bytes.NewBufferString(`a := accounts.others();`))
+    var dispatchErr *vesupro.DispatchError
+    if !errors.As(err, &dispatchErr) {
+        t.Fatalf("unexpected error %v.", err)
+    }
+    if dispatchErr.Receiver != "accounts" || dispatchErr.Method != "others" ||
+    dispatchErr.Err.Error() != "No account." {
+        t.Errorf("unexpected dispatch error %#v.", dispatchErr)
+    }
+    if err.Error() != "accounts.others: No account." {
+        t.Errorf("unexpected message %q.", err)
+    }
+}
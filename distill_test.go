@@ -0,0 +1,594 @@
+package vesupro_test
+
+import (
+    "./"
+    "./apidistiller"
+    "testing"
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "go/ast"
+    "go/parser"
+    "go/token"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "reflect"
+    "sort"
+    "strings"
+)
+
+const shopSource = `package shop
+
+type Base struct{}
+
+type Named struct{}
+
+// Product embeds Base and Named, both providing Name.
+type Product struct {
+    Base
+    *Named
+    id int
+}
+
+type Other struct{ Named }
+
+type Both struct {
+    Product
+    Other
+}
+
+// vesupro: export
+func (b *Base) ID() {}
+
+// vesupro: export
+func (b *Base) Name() {}
+
+// vesupro: export
+func (n *Named) Name() {}
+
+// vesupro: export
+func (p *Product) Price() {}
+
+// vesupro: export
+func (l *List[T]) Len() {}
+
+// vesupro: export
+func (m Map[K, V]) Keys() {}
+`
+
+func distillSources(sources ...string) (*apidistiller.API, error) {
+    api := apidistiller.NewAPI("shop")
+    for _, src := range sources {
+        f, err := parser.ParseFile(token.NewFileSet(), "shop.go", src,
+            parser.ParseComments)
+        if err != nil { return nil, err }
+        if err := api.DistillFromAstFile(f); err != nil { return nil, err }
+    }
+    return api, nil
+}
+
+// methodSets lists the methods of every type of api, promoted ones with the
+// embedded type they are promoted from.
+func methodSets(api *apidistiller.API) string {
+    var sets []string
+    for typeName, methods := range api.Methods {
+        var names []string
+        for _, m := range methods {
+            name := m.Name
+            if m.Promoted != "" {
+                name += "<" + m.Promoted
+            }
+            names = append(names, name)
+        }
+        sets = append(sets, fmt.Sprintf("%s%v", typeName, names))
+    }
+    sort.Strings(sets)
+    return strings.Join(sets, " ")
+}
+
+func TestDistill_Receivers(t *testing.T) {
+    tests := []struct {
+        sources []string
+        out string
+    }{
+        {sources: []string{shopSource},
+        out: "Base[ID Name] Both[Price<Product ID<Product Name<Other] " +
+            "List[Len] Map[Keys] Named[Name] Other[Name<Named] " +
+            "Product[Price ID<Base]"},
+        // declared methods shadow promoted ones, even if declared later
+        {sources: []string{shopSource, "package shop\n\n" +
+            "// vesupro: export\nfunc (p *Product) Name() {}\n"},
+        out: "Base[ID Name] Both[Price<Product Name<Product ID<Product] " +
+            "List[Len] Map[Keys] Named[Name] Other[Name<Named] " +
+            "Product[Price Name ID<Base]"},
+    }
+
+    for i, tt := range tests {
+        api, err := distillSources(tt.sources...)
+        if err != nil {
+            t.Errorf("%d. error: %q", i, err)
+        } else if methodSets(api) != tt.out {
+            t.Errorf("%d. in/out mismatch %q != %q.", i, tt.out,
+                methodSets(api))
+        }
+    }
+}
+
+func TestDistill_ReceiverErrors(t *testing.T) {
+    tests := []struct {
+        sources []string
+        err string
+    }{
+        {sources: []string{"package shop\n\n" +
+            "// vesupro: export\nfunc (p **Product) Price() {}\n"},
+        err: "Unsupported receiver type **Product of method Price."},
+        {sources: []string{"package shop\n\n" +
+            "// vesupro: export\nfunc (p shop.Product) Price() {}\n"},
+        err: "Unsupported receiver type shop.Product of method Price."},
+        {sources: []string{shopSource, shopSource},
+        err: "Method ID of Base is declared twice."},
+        {sources: []string{shopSource, "package shop\n\n" +
+            "// vesupro: export\nfunc (p Product) price() {}\n"},
+        err: "Method price of Product is declared twice."},
+    }
+
+    for i, tt := range tests {
+        _, err := distillSources(tt.sources...)
+        if err == nil || err.Error() != tt.err {
+            t.Errorf("%d. error mismatch %q != %v.", i, tt.err, err)
+        }
+    }
+}
+
+const accountsSource = `package accounts
+
+// vesupro: export
+func (a *Accounts) Get(ctx context.Context, id int64) (*Account, error) {}
+
+// vesupro: export
+func (a *Accounts) Count() int {}
+`
+
+func TestDistill_Context(t *testing.T) {
+    f, err := parser.ParseFile(token.NewFileSet(), "accounts.go",
+        accountsSource, parser.ParseComments)
+    if err != nil { t.Fatal(err) }
+    api := apidistiller.NewAPI("accounts")
+    if err := api.DistillFromAstFile(f); err != nil { t.Fatal(err) }
+
+    get, count := api.Method("Accounts", "get"), api.Method("Accounts", "count")
+    if !get.TakesContext || !get.ReturnsError || len(get.Params) != 1 ||
+    get.Params[0].Name != "id" || get.Params[0].Position != 0 {
+        t.Errorf("unexpected method %#v.", get)
+    }
+    if count.TakesContext || count.ReturnsError {
+        t.Errorf("unexpected method %#v.", count)
+    }
+
+    src := `package accounts
+
+// vesupro: export
+func (a *Accounts) Get(id int64, ctx context.Context) {}
+`
+    f, err = parser.ParseFile(token.NewFileSet(), "accounts.go", src,
+        parser.ParseComments)
+    if err != nil { t.Fatal(err) }
+    err = apidistiller.NewAPI("accounts").DistillFromAstFile(f)
+    exp := "context.Context must be the first parameter of method Get."
+    if err == nil || err.Error() != exp {
+        t.Errorf("error mismatch %q != %v.", exp, err)
+    }
+}
+
+type List[T any] struct{ items []T }
+
+func TestTypeName(t *testing.T) {
+    if name := vesupro.TypeName(&List[int]{}); name != "List" {
+        t.Errorf("unexpected type name %q.", name)
+    }
+}
+
+const billingSource = `package billing
+
+// vesupro: export
+func (i *Invoices) Pay(id int64, product *Product) {}
+`
+
+func qualifiedAPI(t *testing.T, src string, path string) *apidistiller.API {
+    f, err := parser.ParseFile(token.NewFileSet(), "api.go", src,
+        parser.ParseComments)
+    if err != nil { t.Fatal(err) }
+    api := apidistiller.NewAPI(f.Name.Name)
+    if err := api.DistillFromAstFile(f); err != nil { t.Fatal(err) }
+    return api.Qualify(path)
+}
+
+func TestAPI_Merge(t *testing.T) {
+    api := apidistiller.NewAPI("")
+    for _, other := range []*apidistiller.API{
+        qualifiedAPI(t, shopSource, "example.com/shop"),
+        qualifiedAPI(t, billingSource, "example.com/billing"),
+        qualifiedAPI(t, shopSource, "example.com/legacy/shop"),
+    } {
+        if err := api.Merge(other); err != nil { t.Fatal(err) }
+    }
+
+    pay := api.Method("example.com/billing.Invoices", "pay")
+    if pay == nil || pay.Params[1].TypeName != "example.com/billing.Product" {
+        t.Fatalf("unexpected method %#v.", pay)
+    }
+    product := api.Method("example.com/shop.Product", "iD")
+    if product == nil || product.Promoted != "example.com/shop.Base" {
+        t.Errorf("unexpected method %#v.", product)
+    }
+
+    var imports []string
+    for _, imp := range api.Imports() {
+        imports = append(imports, fmt.Sprint(*imp))
+    }
+    exp := "[{example.com/billing billing } " +
+        "{example.com/legacy/shop shop } {example.com/shop shop shop2}]"
+    if fmt.Sprint(imports) != exp {
+        t.Errorf("imports mismatch %q != %q.", exp, fmt.Sprint(imports))
+    }
+    names := strings.Join([]string{api.SourceName("example.com/shop.Product"),
+        api.SourceName("example.com/legacy/shop.Product"),
+        api.SourceName("time.Time")}, " ")
+    if names != "shop2.Product shop.Product time.Time" {
+        t.Errorf("unexpected source names %q.", names)
+    }
+}
+
+const baseSource = `package base
+
+type Entity struct{}
+
+// vesupro: export
+func (e *Entity) Touch(at int64) {}
+`
+
+const catalogSource = `package shop
+
+import "example.com/base"
+
+type Filter struct{ Query string }
+
+type Product struct{ base.Entity }
+
+// vesupro: export default limit=10
+func (p *Product) Find(f *Filter, limit int, tags ...string) {}
+`
+
+func TestAPI_MergePromotion(t *testing.T) {
+    api := apidistiller.NewAPI("")
+    for _, other := range []*apidistiller.API{
+        qualifiedAPI(t, catalogSource, "example.com/a/shop"),
+        qualifiedAPI(t, catalogSource, "example.com/b/shop"),
+        qualifiedAPI(t, "package shop2\n", "example.com/shop2"),
+        qualifiedAPI(t, baseSource, "example.com/base"),
+    } {
+        if err := api.Merge(other); err != nil { t.Fatal(err) }
+    }
+
+    // the embedded type of another package is merged last
+    touch := api.Method("example.com/b/shop.Product", "touch")
+    if touch == nil || touch.Promoted != "example.com/base.Entity" {
+        t.Errorf("unexpected method %#v.", touch)
+    }
+
+    var imports []string
+    for _, imp := range api.Imports() {
+        imports = append(imports, fmt.Sprint(*imp))
+    }
+    exp := "[{example.com/a/shop shop } {example.com/b/shop shop shop3} " +
+        "{example.com/base base } {example.com/shop2 shop2 }]"
+    if fmt.Sprint(imports) != exp {
+        t.Errorf("imports mismatch %q != %q.", exp, fmt.Sprint(imports))
+    }
+}
+
+func TestAPI_GenerateDecoders(t *testing.T) {
+    api := apidistiller.NewAPI("")
+    for _, other := range []*apidistiller.API{
+        qualifiedAPI(t, catalogSource, "example.com/shop"),
+        qualifiedAPI(t, baseSource, "example.com/base"),
+    } {
+        if err := api.Merge(other); err != nil { t.Fatal(err) }
+    }
+
+    out := &bytes.Buffer{}
+    err := api.GenerateDecoders(out, "decoders", "example.com/vesupro")
+    if err != nil { t.Fatal(err) }
+    f, err := parser.ParseFile(token.NewFileSet(), "decoders.go", out, 0)
+    if err != nil { t.Fatalf("generated code: %s\n%s", err, out) }
+
+    var imports, decls []string
+    for _, imp := range f.Imports {
+        imports = append(imports, imp.Path.Value)
+    }
+    for _, decl := range f.Decls {
+        if fn, ok := decl.(*ast.FuncDecl); ok {
+            decls = append(decls, fn.Name.Name)
+        }
+    }
+    // the receiver package base is not referenced by any argument
+    exp := `["example.com/shop" "example.com/vesupro" "fmt"]`
+    if fmt.Sprint(imports) != exp {
+        t.Errorf("imports mismatch %s != %s.", exp, fmt.Sprint(imports))
+    }
+    exp = "[DecodeBaseEntityTouch DecodeShopProductFind " +
+        "DecodeShopProductTouch]"
+    if fmt.Sprint(decls) != exp {
+        t.Errorf("decoders mismatch %s != %s.", exp, fmt.Sprint(decls))
+    }
+
+    err = apidistiller.NewAPI("shop").GenerateDecoders(out, "decoders", "")
+    if err == nil || err.Error() != "API of package shop has no methods." {
+        t.Errorf("unexpected error %v.", err)
+    }
+}
+
+const decoderMain = `package main
+
+import (
+    "bytes"
+    "fmt"
+    "os"
+    "time"
+    vesupro %q
+)
+
+func main() {
+    for _, program := range os.Args[1:] {
+        t := vesupro.NewTokenizer(bytes.NewBufferString(program))
+        defs, err := vesupro.ParseDefinitions(t)
+        if err != nil { panic(err) }
+        args, err := DecodeShopFind(defs[0].MethodCalls[0])
+        if err != nil {
+            fmt.Println(err)
+            continue
+        }
+        fmt.Printf("%%q %%d %%d %%s %%q\n", args.Query, args.Limit, args.Page,
+            args.Since.Format(time.RFC3339), args.Tags)
+    }
+}
+`
+
+func TestAPI_GeneratedDecoders(t *testing.T) {
+    if testing.Short() { t.Skip("compiles a program") }
+    goTool, err := exec.LookPath("go")
+    if err != nil { t.Skip("no go tool") }
+
+    api, err := distillSources(`package shop
+
+import "time"
+
+// vesupro: export
+func (s *Shop) Find(query string, limit int8, page uint16, since time.Time,
+tags ...string) {}
+`)
+    if err != nil { t.Fatal(err) }
+    // the program has to be compiled in this tree to import vesupro
+    dir, err := os.MkdirTemp(".", "decoders")
+    if err != nil { t.Fatal(err) }
+    defer os.RemoveAll(dir)
+    vesuproPath := reflect.TypeOf(vesupro.MethodCall{}).PkgPath()
+    if strings.HasPrefix(vesuproPath, "_") {
+        // outside of GOPATH, only relative imports are possible
+        vesuproPath = ".."
+    }
+    out := &bytes.Buffer{}
+    if err := api.GenerateDecoders(out, "main", vesuproPath); err != nil {
+        t.Fatal(err)
+    }
+    err = os.WriteFile(filepath.Join(dir, "decoders.go"), out.Bytes(), 0644)
+    if err != nil { t.Fatal(err) }
+    err = os.WriteFile(filepath.Join(dir, "main.go"),
+        []byte(fmt.Sprintf(decoderMain, vesuproPath)), 0644)
+    if err != nil { t.Fatal(err) }
+
+    tests := []struct {
+        in string
+        out string
+    }{
+        {in: `r := s.find("a\nb", 100, 300, "2006-01-02T15:04:05Z", "x",
+            "y\"z");`,
+        out: `"a\nb" 100 300 2006-01-02T15:04:05Z ["x" "y\"z"]`},
+        {in: `r := s.find("a", 300, 1, "2006-01-02T15:04:05Z");`,
+        out: "Argument limit of find: ToIntN(): 300 is out of range."},
+        {in: `r := s.find("a", -128, 65536, "2006-01-02T15:04:05Z");`,
+        out: "Argument page of find: ToUintN(): 65536 is out of range."},
+        {in: `r := s.find("a", 1, -1, "2006-01-02T15:04:05Z");`,
+        out: "Argument page of find: ToUintN(): -1 is out of range."},
+    }
+    cmd := exec.Command(goTool, "run", "./"+filepath.Base(dir))
+    for _, tt := range tests {
+        cmd.Args = append(cmd.Args, tt.in)
+    }
+    output, err := cmd.CombinedOutput()
+    if err != nil { t.Fatalf("%s\n%s\n%s", err, output, out) }
+    lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+    for i, tt := range tests {
+        if i >= len(lines) || lines[i] != tt.out {
+            t.Errorf("%d. in/out mismatch %q != %q.", i, tt.out, lines)
+        }
+    }
+}
+
+func TestAPI_MergeErrors(t *testing.T) {
+    shop := qualifiedAPI(t, shopSource, "example.com/shop")
+    tests := []struct {
+        api *apidistiller.API
+        other *apidistiller.API
+        err string
+    }{
+        {api: shop, other: qualifiedAPI(t, shopSource, "example.com/shop"),
+        err: "Method ID of example.com/shop.Base is declared twice."},
+        {api: shop, other: qualifiedAPI(t, billingSource, "example.com/shop"),
+        err: "Import path example.com/shop is used for packages shop and " +
+            "billing."},
+        {api: apidistiller.NewAPI(""), other: apidistiller.NewAPI("billing"),
+        err: "API of package billing is not qualified."},
+    }
+
+    for i, tt := range tests {
+        err := tt.api.Merge(tt.other)
+        if err == nil || err.Error() != tt.err {
+            t.Errorf("%d. error mismatch %q != %v.", i, tt.err, err)
+        }
+    }
+}
+
+func TestEvaluator_QualifiedAPI(t *testing.T) {
+    path := strings.TrimSuffix(vesupro.QualifiedTypeName(&Search{}),
+        ".Search")
+    api := apidistiller.NewAPI("")
+    if err := api.Merge(qualifiedAPI(t, searchSource, path)); err != nil {
+        t.Fatal(err)
+    }
+    // results are qualified, so that chained calls can be resolved
+    in := api.Method(path+".Search", "in")
+    if in == nil || in.Result != path+".Search" {
+        t.Errorf("unexpected method %#v.", in)
+    }
+    ev := vesupro.NewEvaluator(map[string]vesupro.VesuproObject{
+        "search": &Search{},
+    })
+    ev.API = api
+
+    out := &bytes.Buffer{}
+    err := ev.Evaluate(out, bytes.NewBufferString(`r := search.run("x");`))
+    if err != nil {
+        t.Fatalf("error: %q", err)
+    }
+    if exp := `{"r":["\"x\"","10","false","\"en\""]}`; out.String() != exp {
+        t.Errorf("in/out mismatch %q != %q.", exp, out.String())
+    }
+}
+
+func TestDistill_TextTypeOfOtherFile(t *testing.T) {
+    sources := []string{`package shop
+
+// vesupro: export
+func (s *Shop) Since(day Day) {}
+`, `package shop
+
+func (d *Day) UnmarshalText(text []byte) error { return nil }
+`}
+    // the text type has to be found whichever file comes first
+    for _, order := range [][]int{{0, 1}, {1, 0}} {
+        var files []*ast.File
+        for _, i := range order {
+            f, err := parser.ParseFile(token.NewFileSet(), "shop.go",
+                sources[i], parser.ParseComments)
+            if err != nil { t.Fatal(err) }
+            files = append(files, f)
+        }
+        api := apidistiller.NewAPI("shop")
+        if err := api.DistillFromAstFiles(files); err != nil {
+            t.Errorf("%v. error: %q", order, err)
+        } else if !api.Method("Shop", "since").Params[0].IsText {
+            t.Errorf("%v. day is not a text parameter.", order)
+        }
+    }
+}
+
+func TestDistill_TextTypeOfOtherPackage(t *testing.T) {
+    f, err := parser.ParseFile(token.NewFileSet(), "shop.go", `package shop
+
+import (
+    "time"
+    id "github.com/google/uuid"
+)
+
+// vesupro: export
+func (s *Shop) Get(key id.UUID, since time.Time) {}
+`, parser.ParseComments)
+    if err != nil { t.Fatal(err) }
+    api := apidistiller.NewAPI("shop")
+    api.TextTypes["id.UUID"] = true
+    if err := api.DistillFromAstFile(f); err != nil { t.Fatal(err) }
+    api = api.Qualify("example.com/shop")
+
+    get := api.Method("example.com/shop.Shop", "get")
+    names := get.Params[0].TypeName + " " + get.Params[1].TypeName
+    if names != "github.com/google/uuid.UUID time.Time" {
+        t.Errorf("unexpected type names %q.", names)
+    }
+    var imports []string
+    for _, imp := range api.Imports() {
+        imports = append(imports, fmt.Sprint(*imp))
+    }
+    exp := "[{example.com/shop shop } {github.com/google/uuid uuid } " +
+        "{time time }]"
+    if fmt.Sprint(imports) != exp {
+        t.Errorf("imports mismatch %q != %q.", exp, fmt.Sprint(imports))
+    }
+}
+
+func TestDiff(t *testing.T) {
+    from, err := distillSources(`package shop
+
+// vesupro: export default limit=10
+func (s *Shop) Find(query string, limit int) {}
+
+// vesupro: export
+func (s *Shop) Get(id int64) {}
+
+// vesupro: export
+func (s *Shop) Tagged(tags ...string) {}
+
+// vesupro: export
+func (c *Cart) Add(id int64) {}
+`)
+    if err != nil { t.Fatal(err) }
+    to, err := distillSources(`package shop
+
+// vesupro: export default limit=10 default exact=false
+func (s *Shop) Find(query string, limit int, exact bool) {}
+
+// vesupro: export
+func (s *Shop) Fetch(id int64) {}
+
+// vesupro: export
+func (s *Shop) Tagged(tags []string) {}
+
+// vesupro: export
+func (s *Shop) Total() {}
+
+// vesupro: export
+func (o *Orders) List() {}
+`)
+    if err != nil { t.Fatal(err) }
+
+    var changes []string
+    for _, change := range apidistiller.Diff(from, to) {
+        changes = append(changes, change.String())
+    }
+    exp := []string{
+        "breaking: Receiver Cart removed.",
+        "compatible: Receiver Orders added.",
+        "compatible: Method find of Shop changed arity from 2 to 3 " +
+            "(1 to 1 required).",
+        "breaking: Method get of Shop renamed to fetch.",
+        "breaking: Method tagged of Shop changed arity from 1 to 1 " +
+            "(0 to 1 required).",
+        "breaking: Parameter tags of tagged of Shop changed type from " +
+            "...string to []string.",
+        "compatible: Method total of Shop added.",
+    }
+    if strings.Join(changes, "\n") != strings.Join(exp, "\n") {
+        t.Errorf("changes mismatch\n%s\n!=\n%s.", strings.Join(exp, "\n"),
+            strings.Join(changes, "\n"))
+    }
+
+    // snapshots are the API encoded as JSON
+    snapshot, err := json.Marshal(to)
+    if err != nil { t.Fatal(err) }
+    decoded := &apidistiller.API{}
+    if err := json.Unmarshal(snapshot, decoded); err != nil { t.Fatal(err) }
+    if changes := apidistiller.Diff(to, decoded); len(changes) != 0 {
+        t.Errorf("unexpected changes %v.", changes)
+    }
+}
@@ -0,0 +1,359 @@
+package vesupro
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io"
+    "math"
+    "sort"
+)
+
+// ResultEncoder encodes the results of a program, a map from target names to
+// the objects the definitions evaluated to.
+type ResultEncoder interface {
+    // ContentType returns the media type of the encoding.
+    ContentType() string
+    // NewResultWriter starts a result map with n entries on w.
+    NewResultWriter(w io.Writer, n int) (ResultWriter, error)
+}
+
+// ResultWriter writes the entries of a single result map.
+type ResultWriter interface {
+    WriteResult(target string, obj VesuproObject) error
+    // Close terminates the result map.
+    Close() error
+}
+
+// MsgpackMarshaler is implemented by objects which can encode themselves as
+// MessagePack. Other objects are converted from their JSON encoding.
+type MsgpackMarshaler interface {
+    MarshalMsgpack() ([]byte, error)
+}
+
+// CBORMarshaler is implemented by objects which can encode themselves as
+// CBOR. Other objects are converted from their JSON encoding.
+type CBORMarshaler interface {
+    MarshalCBOR() ([]byte, error)
+}
+
+// # JSON #
+
+// JSONEncoder is the default ResultEncoder. By default, the output of
+// MarshalJSON is written verbatim after being validated.
+type JSONEncoder struct {
+    // Compact removes insignificant whitespace from the results and the
+    // newline separating them.
+    Compact bool
+    // Indent, if not empty, pretty-prints the results using Indent for each
+    // level of indentation. It takes precedence over Compact.
+    Indent string
+}
+
+func (JSONEncoder) ContentType() string { return "application/json" }
+
+func (enc JSONEncoder) NewResultWriter(w io.Writer,
+n int) (ResultWriter, error) {
+    rw := &jsonResultWriter{jsonWriter: jsonWriter{w: w}, enc: enc,
+        first: true}
+    rw.write([]byte{'{'})
+    return rw, rw.err
+}
+
+type jsonResultWriter struct {
+    jsonWriter
+    enc JSONEncoder
+    first bool
+}
+
+func (rw *jsonResultWriter) WriteResult(target string,
+obj VesuproObject) error {
+    jsonOut, err := obj.MarshalJSON()
+    if err != nil { return err }
+
+    if !rw.first {
+        rw.write([]byte{','})
+    }
+    switch {
+    case rw.enc.Indent != "":
+        rw.write([]byte{'\n'})
+        rw.write([]byte(rw.enc.Indent))
+    case !rw.first && !rw.enc.Compact:
+        rw.write([]byte{'\n'})
+    }
+    rw.first = false
+    rw.writeString(target)
+    rw.write([]byte{':'})
+    if rw.enc.Indent != "" {
+        rw.write([]byte{' '})
+    }
+    rw.writeValue(jsonOut, rw.enc.Compact, rw.enc.Indent, rw.enc.Indent)
+    if rw.err != nil {
+        return fmt.Errorf("Writing target %q failed: %s", target, rw.err)
+    }
+    return nil
+}
+
+func (rw *jsonResultWriter) Close() error {
+    if rw.enc.Indent != "" && !rw.first {
+        rw.write([]byte{'\n'})
+    }
+    rw.write([]byte{'}'})
+    return rw.err
+}
+
+// # Binary encodings #
+
+// binaryEncoder converts values decoded from JSON into a binary format.
+type binaryEncoder interface {
+    mapHeader(buf *bytes.Buffer, n int)
+    arrayHeader(buf *bytes.Buffer, n int)
+    str(buf *bytes.Buffer, s string)
+    integer(buf *bytes.Buffer, i int64)
+    float(buf *bytes.Buffer, f float64)
+    boolean(buf *bytes.Buffer, b bool)
+    null(buf *bytes.Buffer)
+}
+
+// encodeValue encodes a value as produced by a json.Decoder with UseNumber.
+func encodeValue(enc binaryEncoder, buf *bytes.Buffer, v interface{}) error {
+    switch v := v.(type) {
+    case nil:
+        enc.null(buf)
+    case bool:
+        enc.boolean(buf, v)
+    case string:
+        enc.str(buf, v)
+    case json.Number:
+        if i, err := v.Int64(); err == nil {
+            enc.integer(buf, i)
+            return nil
+        }
+        f, err := v.Float64()
+        if err != nil { return err }
+        enc.float(buf, f)
+    case []interface{}:
+        enc.arrayHeader(buf, len(v))
+        for _, elem := range v {
+            if err := encodeValue(enc, buf, elem); err != nil { return err }
+        }
+    case map[string]interface{}:
+        keys := make([]string, 0, len(v))
+        for key := range v {
+            keys = append(keys, key)
+        }
+        sort.Strings(keys)
+        enc.mapHeader(buf, len(v))
+        for _, key := range keys {
+            enc.str(buf, key)
+            if err := encodeValue(enc, buf, v[key]); err != nil { return err }
+        }
+    default:
+        return fmt.Errorf("Cannot encode value of type %T.", v)
+    }
+    return nil
+}
+
+// convertJSON converts the JSON encoding of obj.
+func convertJSON(enc binaryEncoder, obj VesuproObject) ([]byte, error) {
+    jsonOut, err := obj.MarshalJSON()
+    if err != nil { return nil, err }
+
+    var v interface{}
+    dec := json.NewDecoder(bytes.NewReader(jsonOut))
+    dec.UseNumber()
+    if err := dec.Decode(&v); err != nil { return nil, err }
+
+    buf := &bytes.Buffer{}
+    if err := encodeValue(enc, buf, v); err != nil { return nil, err }
+    return buf.Bytes(), nil
+}
+
+// binaryResultWriter writes a map of n entries in a binary encoding.
+type binaryResultWriter struct {
+    w io.Writer
+    enc binaryEncoder
+    marshal func(obj VesuproObject) ([]byte, error)
+}
+
+func newBinaryResultWriter(w io.Writer, n int, enc binaryEncoder,
+marshal func(obj VesuproObject) ([]byte, error)) (ResultWriter, error) {
+    buf := &bytes.Buffer{}
+    enc.mapHeader(buf, n)
+    _, err := buf.WriteTo(w)
+    return &binaryResultWriter{w: w, enc: enc, marshal: marshal}, err
+}
+
+func (rw *binaryResultWriter) WriteResult(target string,
+obj VesuproObject) error {
+    out, err := rw.marshal(obj)
+    if err != nil { return err }
+    buf := &bytes.Buffer{}
+    rw.enc.str(buf, target)
+    buf.Write(out)
+    _, err = buf.WriteTo(rw.w)
+    return err
+}
+
+func (rw *binaryResultWriter) Close() error { return nil }
+
+// # MessagePack #
+
+// MsgpackEncoder encodes results as MessagePack.
+type MsgpackEncoder struct{}
+
+func (MsgpackEncoder) ContentType() string { return "application/msgpack" }
+
+func (MsgpackEncoder) NewResultWriter(w io.Writer,
+n int) (ResultWriter, error) {
+    return newBinaryResultWriter(w, n, msgpack{},
+        func(obj VesuproObject) ([]byte, error) {
+            if m, ok := UnwrapObject(obj).(MsgpackMarshaler); ok {
+                return m.MarshalMsgpack()
+            }
+            return convertJSON(msgpack{}, obj)
+        })
+}
+
+type msgpack struct{}
+
+func (msgpack) header(buf *bytes.Buffer, n int, fix byte, fixMax int,
+b16 byte, b32 byte) {
+    switch {
+    case n <= fixMax:
+        buf.WriteByte(fix | byte(n))
+    case n <= math.MaxUint16:
+        buf.Write([]byte{b16, byte(n >> 8), byte(n)})
+    default:
+        buf.Write([]byte{b32, byte(n >> 24), byte(n >> 16), byte(n >> 8),
+            byte(n)})
+    }
+}
+
+func (m msgpack) mapHeader(buf *bytes.Buffer, n int) {
+    m.header(buf, n, 0x80, 15, 0xde, 0xdf)
+}
+
+func (m msgpack) arrayHeader(buf *bytes.Buffer, n int) {
+    m.header(buf, n, 0x90, 15, 0xdc, 0xdd)
+}
+
+func (msgpack) str(buf *bytes.Buffer, s string) {
+    n := len(s)
+    switch {
+    case n <= 31:
+        buf.WriteByte(0xa0 | byte(n))
+    case n <= math.MaxUint8:
+        buf.Write([]byte{0xd9, byte(n)})
+    case n <= math.MaxUint16:
+        buf.Write([]byte{0xda, byte(n >> 8), byte(n)})
+    default:
+        buf.Write([]byte{0xdb, byte(n >> 24), byte(n >> 16), byte(n >> 8),
+            byte(n)})
+    }
+    buf.WriteString(s)
+}
+
+func (msgpack) integer(buf *bytes.Buffer, i int64) {
+    switch {
+    case i >= 0 && i <= 127, i < 0 && i >= -32:
+        buf.WriteByte(byte(i))
+    default:
+        buf.WriteByte(0xd3)
+        writeUint64(buf, uint64(i))
+    }
+}
+
+func (msgpack) float(buf *bytes.Buffer, f float64) {
+    buf.WriteByte(0xcb)
+    writeUint64(buf, math.Float64bits(f))
+}
+
+func (msgpack) boolean(buf *bytes.Buffer, b bool) {
+    if b {
+        buf.WriteByte(0xc3)
+    } else {
+        buf.WriteByte(0xc2)
+    }
+}
+
+func (msgpack) null(buf *bytes.Buffer) { buf.WriteByte(0xc0) }
+
+// # CBOR #
+
+// CBOREncoder encodes results as CBOR (RFC 7049).
+type CBOREncoder struct{}
+
+func (CBOREncoder) ContentType() string { return "application/cbor" }
+
+func (CBOREncoder) NewResultWriter(w io.Writer, n int) (ResultWriter, error) {
+    return newBinaryResultWriter(w, n, cbor{},
+        func(obj VesuproObject) ([]byte, error) {
+            if m, ok := UnwrapObject(obj).(CBORMarshaler); ok {
+                return m.MarshalCBOR()
+            }
+            return convertJSON(cbor{}, obj)
+        })
+}
+
+type cbor struct{}
+
+// head writes the initial bytes of a data item of the given major type.
+func (cbor) head(buf *bytes.Buffer, major byte, n uint64) {
+    major <<= 5
+    switch {
+    case n < 24:
+        buf.WriteByte(major | byte(n))
+    case n <= math.MaxUint8:
+        buf.Write([]byte{major | 24, byte(n)})
+    case n <= math.MaxUint16:
+        buf.Write([]byte{major | 25, byte(n >> 8), byte(n)})
+    case n <= math.MaxUint32:
+        buf.Write([]byte{major | 26, byte(n >> 24), byte(n >> 16),
+            byte(n >> 8), byte(n)})
+    default:
+        buf.WriteByte(major | 27)
+        writeUint64(buf, n)
+    }
+}
+
+func (c cbor) mapHeader(buf *bytes.Buffer, n int) { c.head(buf, 5, uint64(n)) }
+
+func (c cbor) arrayHeader(buf *bytes.Buffer, n int) {
+    c.head(buf, 4, uint64(n))
+}
+
+func (c cbor) str(buf *bytes.Buffer, s string) {
+    c.head(buf, 3, uint64(len(s)))
+    buf.WriteString(s)
+}
+
+func (c cbor) integer(buf *bytes.Buffer, i int64) {
+    if i < 0 {
+        c.head(buf, 1, uint64(-(i + 1)))
+    } else {
+        c.head(buf, 0, uint64(i))
+    }
+}
+
+func (cbor) float(buf *bytes.Buffer, f float64) {
+    buf.WriteByte(0xfb)
+    writeUint64(buf, math.Float64bits(f))
+}
+
+func (cbor) boolean(buf *bytes.Buffer, b bool) {
+    if b {
+        buf.WriteByte(0xf5)
+    } else {
+        buf.WriteByte(0xf4)
+    }
+}
+
+func (cbor) null(buf *bytes.Buffer) { buf.WriteByte(0xf6) }
+
+func writeUint64(buf *bytes.Buffer, n uint64) {
+    for shift := uint(56); ; shift -= 8 {
+        buf.WriteByte(byte(n >> shift))
+        if shift == 0 { break }
+    }
+}
@@ -0,0 +1,160 @@
+package vesupro_test
+
+import (
+    "./"
+    "testing"
+    "bytes"
+    "fmt"
+    "io"
+)
+
+type jsonObject string
+
+func (j jsonObject) Dispatch(mc *vesupro.MethodCall) (vesupro.VesuproObject, error) {
+    return j, nil
+}
+
+func (j jsonObject) MarshalJSON() ([]byte, error) {
+    return []byte(j), nil
+}
+
+type msgpackObject struct{ jsonObject }
+
+func (m msgpackObject) Dispatch(mc *vesupro.MethodCall) (vesupro.VesuproObject, error) {
+    return m, nil
+}
+
+func (msgpackObject) MarshalMsgpack() ([]byte, error) {
+    return []byte{0xc3}, nil
+}
+
+func TestEncoders(t *testing.T) {
+    obj := jsonObject(`{"a": 1, "b": [true, null], "c": "x", "d": 1.5, "e": -5}`)
+
+    tests := []struct {
+        enc vesupro.ResultEncoder
+        obj vesupro.VesuproObject
+        out []byte
+    }{
+        {enc: vesupro.JSONEncoder{}, obj: obj,
+        out: []byte(`{"v1":` + string(obj) + `}`)},
+
+        {enc: vesupro.MsgpackEncoder{}, obj: obj,
+        out: []byte{0x81, 0xa2, 'v', '1', 0x85,
+            0xa1, 'a', 0x01,
+            0xa1, 'b', 0x92, 0xc3, 0xc0,
+            0xa1, 'c', 0xa1, 'x',
+            0xa1, 'd', 0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0,
+            0xa1, 'e', 0xfb}},
+
+        {enc: vesupro.CBOREncoder{}, obj: obj,
+        out: []byte{0xa1, 0x62, 'v', '1', 0xa5,
+            0x61, 'a', 0x01,
+            0x61, 'b', 0x82, 0xf5, 0xf6,
+            0x61, 'c', 0x61, 'x',
+            0x61, 'd', 0xfb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0,
+            0x61, 'e', 0x24}},
+
+        {enc: vesupro.MsgpackEncoder{}, obj: msgpackObject{obj},
+        out: []byte{0x81, 0xa2, 'v', '1', 0xc3}},
+
+        {enc: vesupro.CBOREncoder{}, obj: msgpackObject{obj},
+        out: []byte{0xa1, 0x62, 'v', '1', 0xa5,
+            0x61, 'a', 0x01,
+            0x61, 'b', 0x82, 0xf5, 0xf6,
+            0x61, 'c', 0x61, 'x',
+            0x61, 'd', 0xfb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0,
+            0x61, 'e', 0x24}},
+    }
+
+    for i, tt := range tests {
+        ev := &vesupro.Evaluator{
+            SymTable: map[string]vesupro.VesuproObject{"obj": tt.obj},
+            Encoder: tt.enc,
+        }
+        out := &bytes.Buffer{}
+        err := ev.Evaluate(out, bytes.NewBufferString(`v1 := obj.get();`))
+        if err != nil {
+            t.Errorf("%d. error: %q", i, err)
+        } else if !bytes.Equal(tt.out, out.Bytes()) {
+            t.Errorf("%d. in/out mismatch % x != % x.", i, tt.out, out.Bytes())
+        }
+    }
+}
+
+type failingWriter struct{ n int }
+
+func (f *failingWriter) Write(p []byte) (int, error) {
+    if f.n <= 0 {
+        return 0, fmt.Errorf("write failed")
+    }
+    f.n--
+    return len(p), nil
+}
+
+func TestJSONEncoder(t *testing.T) {
+    obj := jsonObject(`{"a": [1, 2]}`)
+
+    tests := []struct {
+        enc vesupro.JSONEncoder
+        targets []string
+        obj vesupro.VesuproObject
+        out string
+    }{
+        {targets: []string{"v1", "v2"}, obj: obj,
+        out: `{"v1":{"a": [1, 2]},` + "\n" + `"v2":{"a": [1, 2]}}`},
+        {targets: []string{"q\"uote", "back\\slash\n", "\u2028\x01"},
+        obj: jsonObject(`1`),
+        out: `{"q\"uote":1,` + "\n" + `"back\\slash\n":1,` + "\n" +
+            `"\u2028\u0001":1}`},
+        {targets: []string{}, enc: vesupro.JSONEncoder{Indent: "  "},
+        out: `{}`},
+        {targets: []string{"v1", "v2"}, obj: obj,
+        enc: vesupro.JSONEncoder{Compact: true},
+        out: `{"v1":{"a":[1,2]},"v2":{"a":[1,2]}}`},
+        {targets: []string{"v1", "v2"}, obj: obj,
+        enc: vesupro.JSONEncoder{Indent: "  "},
+        out: "{\n  \"v1\": {\n    \"a\": [\n      1,\n      2\n    ]\n  }," +
+            "\n  \"v2\": {\n    \"a\": [\n      1,\n      2\n    ]\n  }\n}"},
+    }
+
+    for i, tt := range tests {
+        out := &bytes.Buffer{}
+        rw, err := tt.enc.NewResultWriter(out, len(tt.targets))
+        for _, target := range tt.targets {
+            if err == nil {
+                err = rw.WriteResult(target, tt.obj)
+            }
+        }
+        if err == nil {
+            err = rw.Close()
+        }
+        if err != nil {
+            t.Errorf("%d. error: %q", i, err)
+        } else if tt.out != out.String() {
+            t.Errorf("%d. in/out mismatch %q != %q.", i, tt.out, out.String())
+        }
+    }
+}
+
+func TestJSONEncoder_Errors(t *testing.T) {
+    tests := []struct {
+        w io.Writer
+        obj vesupro.VesuproObject
+    }{
+        {w: &bytes.Buffer{}, obj: jsonObject(`{"a": `)},
+        {w: &bytes.Buffer{}, obj: jsonObject(`1 2`)},
+        {w: &failingWriter{n: 0}, obj: jsonObject(`1`)},
+        {w: &failingWriter{n: 2}, obj: jsonObject(`1`)},
+    }
+
+    for i, tt := range tests {
+        ev := &vesupro.Evaluator{
+            SymTable: map[string]vesupro.VesuproObject{"obj": tt.obj},
+        }
+        err := ev.Evaluate(tt.w, bytes.NewBufferString(`v1 := obj.get();`))
+        if err == nil {
+            t.Errorf("%d. expected error.", i)
+        }
+    }
+}
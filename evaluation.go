@@ -1,6 +1,8 @@
 package vesupro
 
 import (
+    "./apidistiller"
+    "context"
     "io"
     "fmt"
 )
@@ -10,43 +12,304 @@ type VesuproObject interface {
     MarshalJSON()([]byte, error)
 }
 
+// Evaluator evaluates programs against a symbol table.
+type Evaluator struct {
+    SymTable map[string]VesuproObject
+    // Encoder encodes the results. JSONEncoder is used if it is nil.
+    Encoder ResultEncoder
+    // Limits bounds the size of accepted programs. It may be nil.
+    Limits *EvalLimits
+    // Costs prices programs before their execution. It may be nil, in
+    // which case programs are not priced.
+    Costs *CostRegistry
+    // Budget limits the cost of programs per caller. It is only used if
+    // Costs is set and may be nil.
+    Budget BudgetFunc
+    // Authorizer is consulted for every method call before any method is
+    // dispatched. It may be nil.
+    Authorizer Authorizer
+    // Interceptors wrap every Dispatch and MarshalJSON, the first one being
+    // the outermost.
+    Interceptors []Interceptor
+    // Tracer receives spans for the program, its definitions, method calls
+    // and marshalled results. It may be nil.
+    Tracer Tracer
+    // Metrics receives measurements of every evaluation. It may be nil.
+    Metrics Metrics
+    // API describes the methods offered by the symbol table. Named and
+    // omitted arguments of its methods are resolved before calls are
+    // authorized, see ResolveArguments. It bounds the method labels of
+    // Metrics and may be nil.
+    API *apidistiller.API
+    // Cache caches the results of cacheable definitions across programs and
+    // evaluates them only once per program. It may be nil.
+    Cache *ResultCache
+    // DisableSchema removes the introspection receiver SchemaReceiver.
+    DisableSchema bool
+    // Origins maps names of the symbol table which are not receivers of
+    // their own, e.g. the targets a session keeps in scope, to the receiver
+    // they derive from. The Authorizer and the interceptors, and thus the
+    // metrics, see that receiver instead of the name. It may be nil.
+    Origins map[string]string
+}
+
+// EvalInfo carries metadata about an evaluated program.
+type EvalInfo struct {
+    Definitions int
+    Cost int // static cost of the program, 0 if not priced
+}
+
+// NewEvaluator creates an evaluator which encodes results as JSON.
+func NewEvaluator(symTable map[string]VesuproObject) *Evaluator {
+    return &Evaluator{SymTable: symTable, Encoder: JSONEncoder{}}
+}
+
+// Evaluate parses program, evaluates its definitions and writes the results
+// as JSON object to output.
 func Evaluate(output io.Writer, program io.Reader,
 symTable map[string]VesuproObject) error {
-    var err error
+    return NewEvaluator(symTable).Evaluate(output, program)
+}
 
-    t := NewTokenizer(program)
-    defs, err := ParseDefinitions(t)
+// Evaluate parses program, evaluates its definitions and writes the results
+// to output using the evaluator's encoder.
+func (e *Evaluator) Evaluate(output io.Writer, program io.Reader) error {
+    _, err := e.EvaluateContext(context.Background(), output, program)
+    return err
+}
 
-    if err != nil { return err }
+// EvaluateContext is like Evaluate, ctx identifies the caller. Cancelling ctx
+// stops the evaluation before the next method call. The returned info is
+// non-nil as soon as the program has been parsed, even if the evaluation
+// fails afterwards.
+func (e *Evaluator) EvaluateContext(ctx context.Context, output io.Writer,
+program io.Reader) (*EvalInfo, error) {
+    return e.observe(ctx, output, func(ctx context.Context,
+    output io.Writer) (*EvalInfo, error) {
+        return e.evaluate(ctx, output, program)
+    })
+}
 
-    first := true
-    output.Write([]byte{'{'})
+// EvaluateDefinitions is like EvaluateContext for parsed definitions, e.g.
+// those of a PreparedQuery. Scanning and parsing are skipped, the limits but
+// MaxBytes are checked on defs instead, so that bound variables cannot
+// exceed them.
+func (e *Evaluator) EvaluateDefinitions(ctx context.Context, output io.Writer,
+defs []*Definition) (*EvalInfo, error) {
+    return e.observe(ctx, output, func(ctx context.Context,
+    output io.Writer) (*EvalInfo, error) {
+        if err := e.Limits.checkParsed(defs); err != nil { return nil, err }
+        return e.evaluateDefinitions(ctx, output, defs)
+    })
+}
 
-    for _, def := range defs {
-        if first {
-            first = false
-            output.Write([]byte{'"'})
-        } else {
-            output.Write([]byte(",\n\""))
+// evalFunc evaluates a program, writing the results to output.
+type evalFunc func(ctx context.Context, output io.Writer) (*EvalInfo, error)
+
+// observe runs evaluate with the metrics and tracing of the evaluator.
+func (e *Evaluator) observe(ctx context.Context, output io.Writer,
+evaluate evalFunc) (info *EvalInfo, err error) {
+    if e.Metrics != nil {
+        counter := &countingWriter{w: output}
+        output = counter
+        defer func() {
+            definitions := 0
+            if info != nil {
+                definitions = info.Definitions
+            }
+            e.Metrics.ObserveProgram(definitions, counter.n, err)
+        }()
+    }
+
+    if e.Tracer == nil {
+        return evaluate(ctx, output)
+    }
+
+    ctx, span := e.Tracer.StartSpan(ctx, ProgramSpan)
+    info, err = evaluate(ctx, output)
+    if info != nil {
+        span.SetAttributes(Attribute{DefinitionsAttribute, info.Definitions})
+    }
+    span.End(err)
+    return info, err
+}
+
+func (e *Evaluator) evaluate(ctx context.Context, output io.Writer,
+program io.Reader) (*EvalInfo, error) {
+    defs, err := e.Parse(program)
+    if err != nil { return nil, err }
+    return e.evaluateDefinitions(ctx, output, defs)
+}
+
+// Parse parses program within the limits of the evaluator, e.g. to evaluate
+// its definitions with EvaluateDefinitions. Failures are observed by the
+// metrics of the evaluator.
+func (e *Evaluator) Parse(program io.Reader) ([]*Definition, error) {
+    var defs []*Definition
+    var err error
+
+    program, err = e.Limits.readProgram(program)
+    if err == nil {
+        t := NewTokenizer(program)
+        defs, err = ParseDefinitionsWithLimits(t, e.Limits)
+    }
+    if err != nil {
+        if e.Metrics != nil {
+            e.Metrics.ObserveParseFailure(ParseErrorKind(err))
         }
-        output.Write([]byte(def.TargetName))
-        output.Write([]byte(`":`))
+        return nil, err
+    }
+    return defs, nil
+}
+
+// origin returns the receiver the name derives from, see Origins.
+func (e *Evaluator) origin(name string) string {
+    if origin, found := e.Origins[name]; found { return origin }
+    return name
+}
 
-        rcvObj, found := symTable[def.ReceiverName]
-        if !found {
-            return fmt.Errorf("Receiver not found %s.", def.TargetName)
+func (e *Evaluator) evaluateDefinitions(ctx context.Context,
+output io.Writer, defs []*Definition) (*EvalInfo, error) {
+    info := &EvalInfo{Definitions: len(defs)}
+    if names := Variables(defs); len(names) > 0 {
+        return info, fmt.Errorf("Unbound variable $%s.", names[0])
+    }
+    defs, err := e.resolveDefinitions(defs)
+    if err != nil { return info, err }
+    if e.Costs != nil {
+        info.Cost = e.Costs.ProgramCost(defs, e.SymTable)
+        if e.Budget != nil {
+            budget := e.Budget(ctx)
+            if budget >= 0 && info.Cost > budget {
+                return info, &BudgetError{Cost: info.Cost, Budget: budget}
+            }
         }
+    }
 
-        for _, call := range def.MethodCalls {
-            rcvObj, err = rcvObj.Dispatch(call)
-            if err != nil { return err }
+    if e.Authorizer != nil {
+        if err := authorize(ctx, e.Authorizer, defs, e.origin); err != nil {
+            return info, err
         }
-        jsonOut, err := rcvObj.MarshalJSON()
-        if err != nil { return err }
-        output.Write(jsonOut)
     }
 
-    output.Write([]byte{'}'})
+    enc := e.Encoder
+    if enc == nil {
+        enc = JSONEncoder{}
+    }
+    results, err := enc.NewResultWriter(output, len(defs))
+    if err != nil { return info, err }
 
-    return nil
+    var invoker Invoker
+    interceptors := e.Interceptors
+    if e.Metrics != nil {
+        interceptors = append(interceptors[:len(interceptors):len(interceptors)],
+            metricsInterceptor(e.Metrics, e.apiMethods()))
+    }
+    if e.Tracer != nil {
+        interceptors = append(interceptors[:len(interceptors):len(interceptors)],
+            tracingInterceptor(e.Tracer))
+    }
+    if len(interceptors) > 0 {
+        invoker = chainInterceptors(interceptors)
+    }
+
+    state := &evalState{invoker: invoker, seen: make(requestCache),
+        batched: e.batches(defs)}
+
+    for _, def := range defs {
+        err := e.writeDefinition(ctx, state, results, def)
+        if err != nil { return info, err }
+    }
+
+    return info, results.Close()
+}
+
+// evalState is the state of a single evaluation.
+type evalState struct {
+    invoker Invoker // nil without interceptors
+    seen requestCache
+    batched map[*Definition]*batch
+}
+
+// writeDefinition evaluates def and writes its result, within a definition
+// span if tracing is enabled.
+func (e *Evaluator) writeDefinition(ctx context.Context, state *evalState,
+results ResultWriter, def *Definition) (err error) {
+    if e.Tracer != nil {
+        var span Span
+        ctx, span = e.Tracer.StartSpan(ctx, DefinitionSpan,
+            Attribute{TargetAttribute, def.TargetName},
+            Attribute{ReceiverAttribute, def.ReceiverName})
+        defer func() { span.End(err) }()
+    }
+
+    var rcvObj VesuproObject
+    if e.Cache != nil {
+        rcvObj, err = e.evaluateCached(ctx, state, def)
+    } else {
+        rcvObj, err = e.evaluateDefinition(ctx, state, def)
+    }
+    if err != nil { return err }
+    return results.WriteResult(def.TargetName, rcvObj)
+}
+
+// evaluateDefinition dispatches the calls of def through the invoker of
+// state. If def belongs to a batch, the batch is dispatched first unless
+// that happened for an earlier definition, and evaluation continues after
+// the first call. With
+// interceptors, the result is wrapped so that its MarshalJSON passes through
+// them as well.
+func (e *Evaluator) evaluateDefinition(ctx context.Context, state *evalState,
+def *Definition) (VesuproObject, error) {
+    invoker := state.invoker
+    if invoker == nil {
+        invoker = invoke
+    }
+
+    calls := def.MethodCalls
+    rcvObj, found := e.receiver(def.ReceiverName)
+    if b, batched := state.batched[def]; batched {
+        bc, err := e.dispatchBatch(ctx, invoker, b, def)
+        if err != nil { return nil, err }
+        if bc.err != nil { return nil, bc.err }
+        rcvObj, found, calls = bc.obj, true, calls[1:]
+    }
+    if !found {
+        return nil, fmt.Errorf("Receiver not found %s.", def.ReceiverName)
+    }
+
+    for _, call := range calls {
+        if err := ctx.Err(); err != nil { return nil, err }
+        next, err := invoker(ctx, &Call{Kind: DispatchCall,
+            Target: def.TargetName, Receiver: e.origin(def.ReceiverName),
+            Object: rcvObj, MethodCall: call})
+        if err != nil { return nil, err }
+        if next == nil {
+            return nil, fmt.Errorf("Dispatching %s returned no object.",
+                call.Name)
+        }
+        rcvObj = next
+    }
+    if state.invoker == nil { return rcvObj, nil }
+    return &interceptedObject{VesuproObject: rcvObj, ctx: ctx,
+        invoker: state.invoker, target: def.TargetName,
+        receiver: e.origin(def.ReceiverName)}, nil
+}
+
+// EvaluateDefinition dispatches the method calls of def, starting at the
+// receiver found in symTable, and returns the resulting object.
+func EvaluateDefinition(def *Definition,
+symTable map[string]VesuproObject) (VesuproObject, error) {
+    var err error
+
+    rcvObj, found := symTable[def.ReceiverName]
+    if !found {
+        return nil, fmt.Errorf("Receiver not found %s.", def.ReceiverName)
+    }
+
+    for _, call := range def.MethodCalls {
+        rcvObj, err = rcvObj.Dispatch(call)
+        if err != nil { return nil, err }
+    }
+    return rcvObj, nil
 }
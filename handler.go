@@ -0,0 +1,194 @@
+package vesupro
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "io"
+    "io/ioutil"
+    "mime"
+    "net/http"
+    "strconv"
+    "strings"
+)
+
+// CostHeader is the response header carrying the cost of a program.
+const CostHeader = "Vesupro-Cost"
+
+// Handler serves programs posted in the request body. The result encoding
+// is negotiated from the Accept header of the request.
+type Handler struct {
+    Evaluator *Evaluator
+    // Encoders lists the supported encodings, the first one being the
+    // default for requests without preference.
+    Encoders []ResultEncoder
+    // Identify extracts the identity of the caller from a request. The
+    // identity is made available via IdentityFromContext. Requests for which
+    // Identify fails are rejected. It may be nil.
+    Identify func(r *http.Request) (interface{}, error)
+    // Queries holds the prepared queries a request may reference, see
+    // QueryRequest. It may be nil.
+    Queries *QueryRegistry
+    // RegisteredOnly restricts programs to those registered in Queries,
+    // whether referenced or sent as text. Without Queries, all programs are
+    // rejected.
+    RegisteredOnly bool
+}
+
+// NewHandler creates a handler supporting JSON, MessagePack and CBOR.
+func NewHandler(symTable map[string]VesuproObject) *Handler {
+    return &Handler{
+        Evaluator: NewEvaluator(symTable),
+        Encoders: []ResultEncoder{JSONEncoder{}, MsgpackEncoder{},
+            CBOREncoder{}},
+    }
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    if r.Method != "POST" {
+        w.Header().Set("Allow", "POST")
+        http.Error(w, "Method not allowed.", http.StatusMethodNotAllowed)
+        return
+    }
+
+    enc := NegotiateEncoder(r.Header.Get("Accept"), h.Encoders)
+    if enc == nil {
+        http.Error(w, "No acceptable result encoding.",
+            http.StatusNotAcceptable)
+        return
+    }
+
+    ctx := r.Context()
+    if h.Identify != nil {
+        identity, err := h.Identify(r)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusUnauthorized)
+            return
+        }
+        ctx = WithIdentity(ctx, identity)
+    }
+
+    ev := *h.Evaluator
+    ev.Encoder = enc
+    out := &bytes.Buffer{}
+    var info *EvalInfo
+    var err error
+    if h.Queries == nil && !h.RegisteredOnly {
+        info, err = ev.EvaluateContext(ctx, out, r.Body)
+    } else {
+        info, err = h.evaluateQuery(ctx, &ev, out, r.Body)
+    }
+    if info != nil && ev.Costs != nil {
+        w.Header().Set(CostHeader, strconv.Itoa(info.Cost))
+    }
+    if err != nil {
+        status := http.StatusBadRequest
+        switch err.(type) {
+        case *BudgetError:
+            status = http.StatusTooManyRequests
+        case *AuthorizationError, *UnregisteredProgramError:
+            status = http.StatusForbidden
+        case *UnknownQueryError:
+            status = http.StatusNotFound
+        }
+        http.Error(w, err.Error(), status)
+        return
+    }
+
+    w.Header().Set("Content-Type", enc.ContentType())
+    w.Header().Set("Vary", "Accept")
+    out.WriteTo(w)
+}
+
+// evaluateQuery evaluates body, either a QueryRequest or a program. If only
+// registered programs are allowed, a program is evaluated as the query
+// registered for it.
+func (h *Handler) evaluateQuery(ctx context.Context, ev *Evaluator,
+output io.Writer, body io.Reader) (*EvalInfo, error) {
+    program, err := ev.Limits.readProgram(body)
+    if err != nil { return nil, err }
+    data, err := ioutil.ReadAll(program)
+    if err != nil { return nil, &readError{err} }
+
+    var query *PreparedQuery
+    req := &QueryRequest{}
+    if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 &&
+    trimmed[0] == '{' {
+        if err := json.Unmarshal(trimmed, req); err != nil { return nil, err }
+        var found bool
+        if query, found = h.Queries.Lookup(req.ID); !found {
+            return nil, &UnknownQueryError{ID: req.ID}
+        }
+    } else if h.RegisteredOnly {
+        var found bool
+        if query, found = h.Queries.LookupProgram(data); !found {
+            return nil, &UnregisteredProgramError{Hash: QueryHash(data)}
+        }
+    } else {
+        return ev.EvaluateContext(ctx, output, bytes.NewBuffer(data))
+    }
+
+    defs, err := query.Bind(req.Vars)
+    if err != nil { return nil, err }
+    return ev.EvaluateDefinitions(ctx, output, defs)
+}
+
+// NegotiateEncoder picks the encoder with the highest quality in the accept
+// header. As in RFC 9110, the quality of an encoder is that of the most
+// specific media range matching it, so that q=0 excludes it even if a
+// wildcard matches as well. Among encoders of equal quality, one matched more
+// specifically is preferred, then the earlier one. It returns the first
+// encoder if accept is empty and nil if none of the encoders is acceptable.
+func NegotiateEncoder(accept string, encoders []ResultEncoder) ResultEncoder {
+    if len(encoders) == 0 { return nil }
+    if strings.TrimSpace(accept) == "" { return encoders[0] }
+
+    type mediaRange struct {
+        mediaType string
+        q float64
+    }
+    var ranges []mediaRange
+    for _, part := range strings.Split(accept, ",") {
+        mediaType, params, err := mime.ParseMediaType(part)
+        if err != nil { continue }
+        q := 1.0
+        if qs, found := params["q"]; found {
+            q, err = strconv.ParseFloat(qs, 64)
+            if err != nil { continue }
+        }
+        ranges = append(ranges, mediaRange{mediaType, q})
+    }
+
+    var best ResultEncoder
+    bestQ, bestSpecificity := 0.0, -1
+    for _, enc := range encoders {
+        q, specificity := 0.0, -1
+        for _, r := range ranges {
+            s := matchSpecificity(r.mediaType, enc.ContentType())
+            if s > specificity {
+                q, specificity = r.q, s
+            }
+        }
+        if q > bestQ || q > 0 && q == bestQ && specificity > bestSpecificity {
+            best, bestQ, bestSpecificity = enc, q, specificity
+        }
+    }
+    return best
+}
+
+// matchSpecificity returns how specifically the media range pattern matches
+// contentType: 2 for the type itself, 1 for type/*, 0 for */* and -1 if it
+// does not match.
+func matchSpecificity(pattern string, contentType string) int {
+    // some clients still use the unregistered x- prefix
+    if pattern == contentType ||
+    strings.Replace(pattern, "/x-", "/", 1) == contentType {
+        return 2
+    }
+    if pattern == "*/*" { return 0 }
+    if strings.HasSuffix(pattern, "/*") &&
+    strings.HasPrefix(contentType, pattern[:len(pattern)-1]) {
+        return 1
+    }
+    return -1
+}
@@ -0,0 +1,87 @@
+package vesupro_test
+
+import (
+    "./"
+    "testing"
+    "bytes"
+    "net/http"
+    "net/http/httptest"
+)
+
+func TestNegotiateEncoder(t *testing.T) {
+    encoders := []vesupro.ResultEncoder{vesupro.JSONEncoder{},
+        vesupro.MsgpackEncoder{}, vesupro.CBOREncoder{}}
+
+    tests := []struct {
+        accept string
+        contentType string
+    }{
+        {accept: "", contentType: "application/json"},
+        {accept: "*/*", contentType: "application/json"},
+        {accept: "application/cbor", contentType: "application/cbor"},
+        {accept: "application/x-msgpack", contentType: "application/msgpack"},
+        {accept: "application/json;q=0.5, application/msgpack",
+        contentType: "application/msgpack"},
+        {accept: "application/msgpack;q=0.1, application/*;q=0.2",
+        contentType: "application/json"},
+        {accept: "text/html", contentType: ""},
+        // the most specific range determines the quality
+        {accept: "*/*, application/cbor", contentType: "application/cbor"},
+        {accept: "application/json;q=0, */*",
+        contentType: "application/msgpack"},
+        {accept: "application/*;q=0.5, application/msgpack;q=0.5, */*;q=1",
+        contentType: "application/msgpack"},
+        {accept: "application/*, application/json;q=0.2",
+        contentType: "application/msgpack"},
+        {accept: "*/*;q=0", contentType: ""},
+    }
+
+    for i, tt := range tests {
+        enc := vesupro.NegotiateEncoder(tt.accept, encoders)
+        contentType := ""
+        if enc != nil {
+            contentType = enc.ContentType()
+        }
+        if tt.contentType != contentType {
+            t.Errorf("%d. %q negotiation mismatch %q != %q.", i, tt.accept,
+            tt.contentType, contentType)
+        }
+    }
+}
+
+func TestHandler(t *testing.T) {
+    h := vesupro.NewHandler(map[string]vesupro.VesuproObject{
+        "obj": jsonObject(`{"a": 1}`),
+    })
+
+    tests := []struct {
+        accept string
+        program string
+        status int
+        out []byte
+    }{
+        {program: `v1 := obj.get();`, status: http.StatusOK,
+        out: []byte(`{"v1":{"a": 1}}`)},
+        {accept: "application/msgpack", program: `v1 := obj.get();`,
+        status: http.StatusOK,
+        out: []byte{0x81, 0xa2, 'v', '1', 0x81, 0xa1, 'a', 0x01}},
+        {accept: "text/html", program: `v1 := obj.get();`,
+        status: http.StatusNotAcceptable},
+        {program: `v1 := none.get();`, status: http.StatusBadRequest},
+    }
+
+    for i, tt := range tests {
+        req := httptest.NewRequest("POST", "/",
+            bytes.NewBufferString(tt.program))
+        req.Header.Set("Accept", tt.accept)
+        rec := httptest.NewRecorder()
+        h.ServeHTTP(rec, req)
+
+        if tt.status != rec.Code {
+            t.Errorf("%d. status mismatch %d != %d.", i, tt.status, rec.Code)
+        } else if tt.out != nil && !bytes.Equal(tt.out, rec.Body.Bytes()) {
+            t.Errorf("%d. in/out mismatch % x != % x.", i, tt.out,
+            rec.Body.Bytes())
+        }
+    }
+}
@@ -0,0 +1,117 @@
+package vesupro
+
+import (
+    "context"
+    "fmt"
+)
+
+// CallKind distinguishes the invocations seen by interceptors.
+type CallKind int
+
+const (
+    DispatchCall CallKind = iota // VesuproObject.Dispatch
+    MarshalCall                  // VesuproObject.MarshalJSON
+    BatchDispatchCall            // BatchDispatcher.BatchDispatch
+)
+
+// Call describes an intercepted invocation.
+type Call struct {
+    Kind CallKind
+    Target string   // target name of the definition, empty for batches
+    Receiver string // receiver the definition starts at, see Evaluator.Origins
+    Object VesuproObject
+    // MethodCall is the dispatched call, nil for MarshalCall and
+    // BatchDispatchCall.
+    MethodCall *MethodCall
+    // Batch holds the calls of a BatchDispatchCall, one per definition.
+    Batch []*MethodCall
+}
+
+// Invoker performs an intercepted call. For a MarshalCall, the result is a
+// RawJSON holding the output of MarshalJSON, for a BatchDispatchCall a
+// *BatchResult. Errors of the dispatched object are returned as
+// *DispatchError.
+type Invoker func(ctx context.Context, call *Call) (VesuproObject, error)
+
+// Interceptor wraps every Dispatch and MarshalJSON of an evaluation, and
+// every BatchDispatch of a BatchDispatcher receiver. It may inspect or
+// modify call, call next any number of times or not at all, and replace the
+// result.
+//
+// Interceptors have to check the kind of call: MethodCall is nil for a
+// BatchDispatchCall, whose calls are in Batch instead. An interceptor which
+// only knows DispatchCall and MarshalCall has to pass every other kind on to
+// next unchanged.
+type Interceptor func(ctx context.Context, call *Call,
+    next Invoker) (VesuproObject, error)
+
+// RawJSON is an already encoded JSON value.
+type RawJSON []byte
+
+func (r RawJSON) Dispatch(c *MethodCall) (VesuproObject, error) {
+    return nil, fmt.Errorf("Cannot dispatch %s on raw JSON.", c.Name)
+}
+
+func (r RawJSON) MarshalJSON() ([]byte, error) {
+    return r, nil
+}
+
+func invoke(ctx context.Context, call *Call) (VesuproObject, error) {
+    if call.Kind == MarshalCall {
+        out, err := call.Object.MarshalJSON()
+        if err != nil { return nil, err }
+        return RawJSON(out), nil
+    }
+    if call.Kind == BatchDispatchCall {
+        return invokeBatch(call)
+    }
+    obj, err := dispatch(ctx, call.Object, call.MethodCall)
+    if err != nil {
+        return nil, &DispatchError{Receiver: call.Receiver,
+            Method: call.MethodCall.Name, Err: err}
+    }
+    return obj, nil
+}
+
+// chainInterceptors returns an invoker calling interceptors in order, the
+// first interceptor being the outermost one.
+func chainInterceptors(interceptors []Interceptor) Invoker {
+    invoker := Invoker(invoke)
+    for i := len(interceptors) - 1; i >= 0; i-- {
+        interceptor, next := interceptors[i], invoker
+        invoker = func(ctx context.Context,
+        call *Call) (VesuproObject, error) {
+            return interceptor(ctx, call, next)
+        }
+    }
+    return invoker
+}
+
+// interceptedObject routes MarshalJSON of a result through the interceptors.
+type interceptedObject struct {
+    VesuproObject
+    ctx context.Context
+    invoker Invoker
+    target string
+    receiver string
+}
+
+func (o *interceptedObject) MarshalJSON() ([]byte, error) {
+    out, err := o.invoker(o.ctx, &Call{Kind: MarshalCall, Target: o.target,
+        Receiver: o.receiver, Object: o.VesuproObject})
+    if err != nil { return nil, err }
+    if out == nil {
+        return nil, fmt.Errorf("Interceptor returned no result for %s.",
+            o.target)
+    }
+    return out.MarshalJSON()
+}
+
+// UnwrapObject returns the object wrapped for interception, if any. Results
+// passed to a ResultWriter may be wrapped.
+func UnwrapObject(obj VesuproObject) VesuproObject {
+    if o, ok := obj.(*interceptedObject); ok {
+        return o.VesuproObject
+    }
+    return obj
+}
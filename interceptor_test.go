@@ -0,0 +1,133 @@
+package vesupro_test
+
+import (
+    "./"
+    "testing"
+    "bytes"
+    "context"
+    "fmt"
+    "reflect"
+)
+
+// flakyObject fails every other dispatch.
+type flakyObject struct {
+    attempts int
+}
+
+func (f *flakyObject) Dispatch(mc *vesupro.MethodCall) (vesupro.VesuproObject, error) {
+    f.attempts++
+    if f.attempts%2 == 1 {
+        return nil, fmt.Errorf("flaky")
+    }
+    return f, nil
+}
+
+func (f *flakyObject) MarshalJSON() ([]byte, error) {
+    return []byte(fmt.Sprintf(`{"attempts": %d}`, f.attempts)), nil
+}
+
+func logging(name string, log *[]string) vesupro.Interceptor {
+    return func(ctx context.Context, call *vesupro.Call,
+    next vesupro.Invoker) (vesupro.VesuproObject, error) {
+        op := "marshal"
+        switch call.Kind {
+        case vesupro.DispatchCall:
+            op = call.MethodCall.Name
+        case vesupro.BatchDispatchCall:
+            op = fmt.Sprintf("batch(%d)", len(call.Batch))
+        }
+        *log = append(*log, name+" before "+call.Target+"."+op)
+        obj, err := next(ctx, call)
+        *log = append(*log, name+" after "+call.Target+"."+op)
+        return obj, err
+    }
+}
+
+func retry(ctx context.Context, call *vesupro.Call,
+next vesupro.Invoker) (vesupro.VesuproObject, error) {
+    obj, err := next(ctx, call)
+    if err != nil {
+        obj, err = next(ctx, call)
+    }
+    return obj, err
+}
+
+func TestEvaluator_Interceptors(t *testing.T) {
+    var log []string
+    ev := vesupro.NewEvaluator(map[string]vesupro.VesuproObject{
+        "obj": &flakyObject{},
+    })
+    ev.Interceptors = []vesupro.Interceptor{
+        logging("outer", &log), retry, logging("inner", &log),
+    }
+
+    out := &bytes.Buffer{}
+    err := ev.Evaluate(out, bytes.NewBufferString(`v1 := obj.a().b();`))
+    if err != nil {
+        t.Fatalf("error: %q", err)
+    }
+
+    expOut := `{"v1":{"attempts": 4}}`
+    if expOut != out.String() {
+        t.Errorf("in/out mismatch %q != %q.", expOut, out.String())
+    }
+
+    expLog := []string{
+        "outer before v1.a",
+        "inner before v1.a", "inner after v1.a", // fails, retried
+        "inner before v1.a", "inner after v1.a",
+        "outer after v1.a",
+        "outer before v1.b",
+        "inner before v1.b", "inner after v1.b",
+        "inner before v1.b", "inner after v1.b",
+        "outer after v1.b",
+        "outer before v1.marshal",
+        "inner before v1.marshal", "inner after v1.marshal",
+        "outer after v1.marshal",
+    }
+    if !reflect.DeepEqual(expLog, log) {
+        t.Errorf("log mismatch %q != %q.", expLog, log)
+    }
+}
+
+func TestEvaluator_InterceptorsReplaceResults(t *testing.T) {
+    ev := vesupro.NewEvaluator(map[string]vesupro.VesuproObject{
+        "obj": &countingObject{},
+    })
+    ev.Interceptors = []vesupro.Interceptor{
+        func(ctx context.Context, call *vesupro.Call,
+        next vesupro.Invoker) (vesupro.VesuproObject, error) {
+            if call.Kind == vesupro.MarshalCall && call.Target == "secret" {
+                return vesupro.RawJSON(`"redacted"`), nil
+            }
+            if call.Kind == vesupro.DispatchCall &&
+            call.MethodCall.Name == "denied" {
+                return nil, fmt.Errorf("denied by interceptor")
+            }
+            return next(ctx, call)
+        },
+    }
+
+    tests := []struct {
+        in string
+        out string
+        err bool
+    }{
+        {in: `v1 := obj.a(); secret := obj.b();`,
+        out: `{"v1":null,` + "\n" + `"secret":"redacted"}`},
+        {in: `v1 := obj.denied();`, err: true},
+    }
+
+    for i, tt := range tests {
+        out := &bytes.Buffer{}
+        err := ev.Evaluate(out, bytes.NewBufferString(tt.in))
+        switch {
+        case tt.err && err == nil:
+            t.Errorf("%d. expected error.", i)
+        case !tt.err && err != nil:
+            t.Errorf("%d. error: %q", i, err)
+        case !tt.err && tt.out != out.String():
+            t.Errorf("%d. in/out mismatch %q != %q.", i, tt.out, out.String())
+        }
+    }
+}
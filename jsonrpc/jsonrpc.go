@@ -0,0 +1,294 @@
+// Package jsonrpc bridges JSON-RPC 2.0 requests to vesupro receivers. The
+// method "users.get" with params [1] is evaluated like the program
+// `result := users.get(1);`, so that the limits, costs, authorization and
+// interceptors of the evaluator apply to it.
+//
+// Params given by name become named arguments, in the order of their names.
+// Numbers become INT or FLOAT arguments, strings STRING, booleans TRUE or
+// FALSE and objects and arrays JSON arguments. null is not supported by the
+// grammar and rejected.
+package jsonrpc
+
+import (
+    ".."
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "net/http"
+    "sort"
+    "strings"
+)
+
+// Error codes. Codes from -32000 to -32099 are specific to this bridge.
+const (
+    ParseError = -32700
+    InvalidRequest = -32600
+    MethodNotFound = -32601
+    InvalidParams = -32602
+    InternalError = -32603
+
+    ServerError = -32000    // evaluation failed
+    Unauthorized = -32001   // see vesupro.AuthorizationError
+    BudgetExceeded = -32002 // see vesupro.BudgetError
+)
+
+// Version is the only supported protocol version.
+const Version = "2.0"
+
+// Request is a JSON-RPC request. A request without ID is a notification.
+type Request struct {
+    Version string `json:"jsonrpc"`
+    Method string `json:"method"`
+    Params json.RawMessage `json:"params,omitempty"`
+    ID json.RawMessage `json:"id,omitempty"`
+}
+
+// Response is a JSON-RPC response.
+type Response struct {
+    Version string `json:"jsonrpc"`
+    Result json.RawMessage `json:"result,omitempty"`
+    Error *Error `json:"error,omitempty"`
+    ID json.RawMessage `json:"id"`
+}
+
+// Error is the error object of a response.
+type Error struct {
+    Code int `json:"code"`
+    Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+    return fmt.Sprintf("%s (%d)", e.Message, e.Code)
+}
+
+// Bridge evaluates JSON-RPC requests.
+type Bridge struct {
+    Evaluator *vesupro.Evaluator
+}
+
+// NewBridge creates a bridge to the receivers of symTable.
+func NewBridge(symTable map[string]vesupro.VesuproObject) *Bridge {
+    return &Bridge{Evaluator: vesupro.NewEvaluator(symTable)}
+}
+
+// Handle answers a single request or a batch. It returns nil if there is
+// nothing to answer, i.e., for notifications. A batch may hold at most
+// MaxDefinitions requests of the evaluator's limits, as each request is
+// evaluated as a program of its own.
+func (b *Bridge) Handle(ctx context.Context, data []byte) []byte {
+    data = bytes.TrimSpace(data)
+    if len(data) > 0 && data[0] == '[' {
+        var batch []json.RawMessage
+        if err := json.Unmarshal(data, &batch); err != nil {
+            return marshal(errorResponse(nil, ParseError, err.Error()))
+        }
+        if len(batch) == 0 {
+            return marshal(errorResponse(nil, InvalidRequest,
+                "Empty batch."))
+        }
+        if limits := b.Evaluator.Limits; limits != nil &&
+        limits.MaxDefinitions > 0 && len(batch) > limits.MaxDefinitions {
+            return marshal(errorResponse(nil, InvalidRequest,
+                fmt.Sprintf("Batch of %d requests exceeds the limit "+
+                "MaxDefinitions of %d.", len(batch), limits.MaxDefinitions)))
+        }
+        var responses []*Response
+        for _, raw := range batch {
+            if resp := b.handle(ctx, raw); resp != nil {
+                responses = append(responses, resp)
+            }
+        }
+        if len(responses) == 0 { return nil }
+        return marshal(responses)
+    }
+
+    resp := b.handle(ctx, data)
+    if resp == nil { return nil }
+    return marshal(resp)
+}
+
+func (b *Bridge) handle(ctx context.Context, data []byte) *Response {
+    req := &Request{}
+    if err := json.Unmarshal(data, req); err != nil {
+        if _, isSyntax := err.(*json.SyntaxError); isSyntax {
+            return errorResponse(nil, ParseError, err.Error())
+        }
+        return errorResponse(nil, InvalidRequest, err.Error())
+    }
+    if req.Version != Version || req.Method == "" {
+        return errorResponse(req.ID, InvalidRequest,
+            "Invalid JSON-RPC 2.0 request.")
+    }
+
+    result, err := b.Call(ctx, req.Method, req.Params)
+    if req.ID == nil { return nil }
+    if err != nil {
+        if rpcErr, ok := err.(*Error); ok {
+            return &Response{Version: Version, Error: rpcErr, ID: req.ID}
+        }
+        return errorResponse(req.ID, InternalError, err.Error())
+    }
+    return &Response{Version: Version, Result: result, ID: req.ID}
+}
+
+// Call evaluates method with params, a JSON array of positional or a JSON
+// object of named arguments. Errors are of type *Error.
+func (b *Bridge) Call(ctx context.Context, method string,
+params json.RawMessage) (json.RawMessage, error) {
+    dot := strings.IndexByte(method, '.')
+    if dot < 0 || strings.IndexByte(method[dot+1:], '.') >= 0 {
+        return nil, &Error{MethodNotFound,
+            fmt.Sprintf("Method %s is not of the form receiver.method.",
+            method)}
+    }
+    receiver, name := method[:dot], method[dot+1:]
+    rcvObj, found := b.Evaluator.SymTable[receiver]
+    if !found || !b.hasMethod(rcvObj, name) {
+        return nil, &Error{MethodNotFound,
+            fmt.Sprintf("Method %s not found.", method)}
+    }
+
+    args, err := arguments(params)
+    if err != nil { return nil, &Error{InvalidParams, err.Error()} }
+
+    def := &vesupro.Definition{TargetName: "result", ReceiverName: receiver,
+        MethodCalls: []*vesupro.MethodCall{
+            &vesupro.MethodCall{Name: name, Arguments: args}}}
+    program := &bytes.Buffer{}
+    err = vesupro.Print(program, []*vesupro.Definition{def})
+    if err != nil { return nil, &Error{InvalidParams, err.Error()} }
+
+    ev := *b.Evaluator
+    ev.Encoder = vesupro.JSONEncoder{}
+    out := &bytes.Buffer{}
+    if _, err := ev.EvaluateContext(ctx, out, program); err != nil {
+        code := ServerError
+        switch err.(type) {
+        case *vesupro.AuthorizationError:
+            code = Unauthorized
+        case *vesupro.BudgetError:
+            code = BudgetExceeded
+        case *vesupro.LimitError, *vesupro.ArgumentError:
+            code = InvalidParams
+        }
+        return nil, &Error{code, err.Error()}
+    }
+
+    var results map[string]json.RawMessage
+    if err := json.Unmarshal(out.Bytes(), &results); err != nil {
+        return nil, &Error{InternalError, err.Error()}
+    }
+    return results[def.TargetName], nil
+}
+
+// hasMethod reports whether the API of the evaluator lists method for the
+// type of rcvObj. Without API, every method is assumed to exist.
+func (b *Bridge) hasMethod(rcvObj vesupro.VesuproObject, method string) bool {
+    api := b.Evaluator.API
+    if api == nil { return true }
+    return api.Method(vesupro.APITypeName(api, rcvObj), method) != nil
+}
+
+// arguments converts params, a JSON array or object, to argument tokens.
+func arguments(params json.RawMessage) ([]*vesupro.ArgumentToken, error) {
+    params = bytes.TrimSpace(params)
+    if len(params) > 0 && params[0] == '{' {
+        return namedArguments(params)
+    }
+    var raw []json.RawMessage
+    if len(params) > 0 {
+        if err := json.Unmarshal(params, &raw); err != nil {
+            return nil, fmt.Errorf("Params must be an array or object.")
+        }
+    }
+    args := make([]*vesupro.ArgumentToken, 0, len(raw))
+    for i, param := range raw {
+        arg, err := vesupro.ArgumentFromJSON(param)
+        if err != nil {
+            return nil, fmt.Errorf("Param %d: %s", i, err)
+        }
+        args = append(args, arg)
+    }
+    return args, nil
+}
+
+func namedArguments(params json.RawMessage) ([]*vesupro.ArgumentToken,
+error) {
+    var raw map[string]json.RawMessage
+    if err := json.Unmarshal(params, &raw); err != nil { return nil, err }
+    names := make([]string, 0, len(raw))
+    for name := range raw {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+
+    args := make([]*vesupro.ArgumentToken, 0, len(raw))
+    for _, name := range names {
+        arg, err := vesupro.ArgumentFromJSON(raw[name])
+        if err == nil && !isIdent(name) {
+            err = fmt.Errorf("Invalid name.")
+        }
+        if err != nil {
+            return nil, fmt.Errorf("Param %s: %s", name, err)
+        }
+        arg.Name = name
+        args = append(args, arg)
+    }
+    return args, nil
+}
+
+// isIdent reports whether name scans as a single identifier.
+func isIdent(name string) bool {
+    t := vesupro.NewTokenizer(bytes.NewBufferString(name))
+    return vesupro.Scan(t, false) == vesupro.IDENT &&
+        vesupro.Scan(t, false) == vesupro.EOF
+}
+
+func errorResponse(id json.RawMessage, code int, message string) *Response {
+    if id == nil {
+        id = json.RawMessage("null")
+    }
+    return &Response{Version: Version, Error: &Error{code, message}, ID: id}
+}
+
+func marshal(v interface{}) []byte {
+    out, err := json.Marshal(v)
+    if err != nil {
+        out, _ = json.Marshal(errorResponse(nil, InternalError, err.Error()))
+    }
+    return out
+}
+
+// ServeHTTP answers JSON-RPC requests posted in the request body. The body
+// may hold at most MaxBytes bytes of the evaluator's limits.
+func (b *Bridge) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    if r.Method != "POST" {
+        w.Header().Set("Allow", "POST")
+        http.Error(w, "Method not allowed.", http.StatusMethodNotAllowed)
+        return
+    }
+    body, maxBytes := r.Body, 0
+    if limits := b.Evaluator.Limits; limits != nil && limits.MaxBytes > 0 {
+        maxBytes = limits.MaxBytes
+        body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+    }
+    data, err := ioutil.ReadAll(body)
+    if err != nil {
+        status := http.StatusBadRequest
+        if maxBytes > 0 && len(data) >= maxBytes {
+            status = http.StatusRequestEntityTooLarge
+        }
+        http.Error(w, err.Error(), status)
+        return
+    }
+
+    out := b.Handle(r.Context(), data)
+    if out == nil {
+        w.WriteHeader(http.StatusNoContent)
+        return
+    }
+    w.Header().Set("Content-Type", "application/json")
+    w.Write(out)
+}
@@ -0,0 +1,223 @@
+package jsonrpc_test
+
+import (
+    "./"
+    ".."
+    "../apidistiller"
+    "testing"
+    "context"
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+)
+
+type Users struct{}
+
+// echo encodes the arguments of the call it results from.
+type echo []string
+
+var tokenNames = map[vesupro.Token]string{
+    vesupro.INT: "INT", vesupro.FLOAT: "FLOAT", vesupro.STRING: "STRING",
+    vesupro.TRUE: "TRUE", vesupro.FALSE: "FALSE", vesupro.JSON: "JSON",
+}
+
+func (u *Users) Dispatch(mc *vesupro.MethodCall) (vesupro.VesuproObject, error) {
+    if mc.Name == "fail" {
+        return nil, fmt.Errorf("Failed.")
+    }
+    e := echo{}
+    for _, arg := range mc.Arguments {
+        e = append(e, fmt.Sprintf("%s:%s", tokenNames[arg.TokenType],
+            arg.TokenContent))
+    }
+    return e, nil
+}
+
+func (u *Users) MarshalJSON() ([]byte, error) {
+    return []byte(`"users"`), nil
+}
+
+func (e echo) Dispatch(mc *vesupro.MethodCall) (vesupro.VesuproObject, error) {
+    return e, nil
+}
+
+func (e echo) MarshalJSON() ([]byte, error) {
+    return json.Marshal([]string(e))
+}
+
+func newBridge() *jsonrpc.Bridge {
+    api := apidistiller.NewAPI("jsonrpc_test")
+    api.Methods["Users"] = []*apidistiller.Method{
+        &apidistiller.Method{Name: "Get"},
+        &apidistiller.Method{Name: "Find", Params: []*apidistiller.Parameter{
+            &apidistiller.Parameter{Name: "query", TypeName: "string"},
+        }},
+        &apidistiller.Method{Name: "Fail"},
+        &apidistiller.Method{Name: "Secret"},
+    }
+    bridge := jsonrpc.NewBridge(map[string]vesupro.VesuproObject{
+        "users": &Users{},
+    })
+    bridge.Evaluator.API = api
+    bridge.Evaluator.Authorizer = vesupro.AuthorizerFunc(
+        func(ctx context.Context, identity interface{}, receiver string,
+        call *vesupro.MethodCall) error {
+            if call.Name == "secret" { return fmt.Errorf("denied") }
+            return nil
+        })
+    return bridge
+}
+
+func TestBridge_Handle(t *testing.T) {
+    tests := []struct {
+        in string
+        out string
+    }{
+        {in: `{"jsonrpc": "2.0", "method": "users.get", ` +
+            `"params": [1, -2.5, 1e3, "a\"b", true, false, {"k": [1]}], ` +
+            `"id": 1}`,
+        out: `{"jsonrpc":"2.0","result":["INT:1","FLOAT:-2.5",` +
+            `"FLOAT:1000.0","STRING:\"a\\\"b\"","TRUE:true",` +
+            `"FALSE:false","JSON:{\"k\":[1]}"],"id":1}`},
+        {in: `{"jsonrpc": "2.0", "method": "users.get", "id": "x"}`,
+        out: `{"jsonrpc":"2.0","result":[],"id":"x"}`},
+        // notification
+        {in: `{"jsonrpc": "2.0", "method": "users.get", "params": [1]}`,
+        out: ``},
+        {in: `{"jsonrpc": "2.0", "method": "users.get", "params": [[1, 2]], ` +
+            `"id": 2}`,
+        out: `{"jsonrpc":"2.0","result":["JSON:[1,2]"],"id":2}`},
+        {in: `{"jsonrpc": "2.0", "method": "users.get", "params": [null], ` +
+            `"id": 2}`,
+        out: `{"jsonrpc":"2.0","error":{"code":-32602,"message":` +
+            `"Param 0: Null is not supported."},"id":2}`},
+        {in: `{"jsonrpc": "2.0", "method": "users.find", ` +
+            `"params": {"query": "x"}, "id": 2}`,
+        out: `{"jsonrpc":"2.0","result":["STRING:\"x\""],"id":2}`},
+        {in: `{"jsonrpc": "2.0", "method": "users.get", ` +
+            `"params": {"b": "x", "a": 1}, "id": 2}`,
+        out: `{"jsonrpc":"2.0","error":{"code":-32602,"message":` +
+            `"Unknown parameter a of get."},"id":2}`},
+        {in: `{"jsonrpc": "2.0", "method": "users.get", ` +
+            `"params": {"a-b": 1}, "id": 2}`,
+        out: `{"jsonrpc":"2.0","error":{"code":-32602,"message":` +
+            `"Param a-b: Invalid name."},"id":2}`},
+        {in: `{"jsonrpc": "2.0", "method": "users.get", "params": 1, ` +
+            `"id": 2}`,
+        out: `{"jsonrpc":"2.0","error":{"code":-32602,"message":` +
+            `"Params must be an array or object."},"id":2}`},
+        {in: `{"jsonrpc": "2.0", "method": "users.put", "id": 3}`,
+        out: `{"jsonrpc":"2.0","error":{"code":-32601,"message":` +
+            `"Method users.put not found."},"id":3}`},
+        {in: `{"jsonrpc": "2.0", "method": "nobody.get", "id": 3}`,
+        out: `{"jsonrpc":"2.0","error":{"code":-32601,"message":` +
+            `"Method nobody.get not found."},"id":3}`},
+        {in: `{"jsonrpc": "2.0", "method": "users.fail", "id": 4}`,
+        out: `{"jsonrpc":"2.0","error":{"code":-32000,"message":` +
+            `"users.fail: Failed."},"id":4}`},
+        {in: `{"jsonrpc": "2.0", "method": "users.secret", "id": 5}`,
+        out: `{"jsonrpc":"2.0","error":{"code":-32001,"message":` +
+            `"Calling secret on users is not authorized: denied"},"id":5}`},
+        {in: `{"jsonrpc": "1.0", "method": "users.get", "id": 6}`,
+        out: `{"jsonrpc":"2.0","error":{"code":-32600,"message":` +
+            `"Invalid JSON-RPC 2.0 request."},"id":6}`},
+        {in: `{"jsonrpc": "2.0", "method": "users.get"`,
+        out: `{"jsonrpc":"2.0","error":{"code":-32700,"message":` +
+            `"unexpected end of JSON input"},"id":null}`},
+        {in: `[]`,
+        out: `{"jsonrpc":"2.0","error":{"code":-32600,"message":` +
+            `"Empty batch."},"id":null}`},
+        {in: `[{"jsonrpc": "2.0", "method": "users.get", "params": [1], ` +
+            `"id": 1}, {"jsonrpc": "2.0", "method": "users.get"}, 1, ` +
+            `{"jsonrpc": "2.0", "method": "users.fail", "id": 2}]`,
+        out: `[{"jsonrpc":"2.0","result":["INT:1"],"id":1},` +
+            `{"jsonrpc":"2.0","error":{"code":-32600,"message":` +
+            `"json: cannot unmarshal number into Go value of type ` +
+            `jsonrpc.Request"},"id":null},` +
+            `{"jsonrpc":"2.0","error":{"code":-32000,"message":` +
+            `"users.fail: Failed."},"id":2}]`},
+        {in: `[{"jsonrpc": "2.0", "method": "users.get"}]`, out: ``},
+    }
+
+    bridge := newBridge()
+    for i, tt := range tests {
+        out := bridge.Handle(context.Background(), []byte(tt.in))
+        if tt.out != string(out) {
+            t.Errorf("%d. in/out mismatch %q != %q.", i, tt.out, out)
+        }
+    }
+}
+
+func TestBridge_ServeHTTP(t *testing.T) {
+    server := httptest.NewServer(newBridge())
+    defer server.Close()
+
+    resp, err := http.Post(server.URL, "application/json", strings.NewReader(
+        `{"jsonrpc": "2.0", "method": "users.get", "params": [7], "id": 1}`))
+    if err != nil {
+        t.Fatalf("error: %q", err)
+    }
+    defer resp.Body.Close()
+    result := &jsonrpc.Response{}
+    if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+        t.Fatalf("error: %q", err)
+    }
+    if string(result.Result) != `["INT:7"]` {
+        t.Errorf("result mismatch %s.", result.Result)
+    }
+
+    resp, err = http.Post(server.URL, "application/json", strings.NewReader(
+        `{"jsonrpc": "2.0", "method": "users.get"}`))
+    if err != nil {
+        t.Fatalf("error: %q", err)
+    }
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusNoContent {
+        t.Errorf("status mismatch %d.", resp.StatusCode)
+    }
+}
+
+func TestBridge_Limits(t *testing.T) {
+    bridge := newBridge()
+    bridge.Evaluator.Limits = &vesupro.EvalLimits{MaxBytes: 128,
+        MaxDefinitions: 2}
+    server := httptest.NewServer(bridge)
+    defer server.Close()
+
+    request := `{"jsonrpc": "2.0", "method": "users.get", "id": 1}`
+    tests := []struct {
+        in string
+        status int
+        out string
+    }{
+        {in: "[" + request + "," + request + "]", status: http.StatusOK,
+        out: `[{"jsonrpc":"2.0","result":[],"id":1},` +
+            `{"jsonrpc":"2.0","result":[],"id":1}]`},
+        {in: "[" + request + "," + request + "," + request + "]",
+        status: http.StatusRequestEntityTooLarge},
+        {in: "[" + request + "," + request + "," + "1]",
+        status: http.StatusOK,
+        out: `{"jsonrpc":"2.0","error":{"code":-32600,"message":` +
+            `"Batch of 3 requests exceeds the limit MaxDefinitions of 2."},` +
+            `"id":null}`},
+    }
+
+    for i, tt := range tests {
+        resp, err := http.Post(server.URL, "application/json",
+            strings.NewReader(tt.in))
+        if err != nil {
+            t.Fatalf("%d. error: %q", i, err)
+        }
+        out, _ := ioutil.ReadAll(resp.Body)
+        resp.Body.Close()
+        if resp.StatusCode != tt.status {
+            t.Errorf("%d. status mismatch %d != %d.", i, tt.status,
+            resp.StatusCode)
+        } else if tt.out != "" && tt.out != string(out) {
+            t.Errorf("%d. in/out mismatch %q != %q.", i, tt.out, out)
+        }
+    }
+}
@@ -0,0 +1,87 @@
+package vesupro
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io"
+    "unicode/utf8"
+)
+
+const hexDigits = "0123456789abcdef"
+
+// jsonWriter writes JSON tokens to an underlying writer. The first write
+// error is kept and all subsequent writes are skipped.
+type jsonWriter struct {
+    w io.Writer
+    err error
+}
+
+func (jw *jsonWriter) write(p []byte) {
+    if jw.err != nil { return }
+    _, jw.err = jw.w.Write(p)
+}
+
+func (jw *jsonWriter) writeString(s string) {
+    buf := &bytes.Buffer{}
+    writeJSONString(buf, s)
+    jw.write(buf.Bytes())
+}
+
+// writeValue validates v and writes it verbatim, compacted or indented.
+func (jw *jsonWriter) writeValue(v []byte, compact bool, prefix string,
+indent string) {
+    if jw.err != nil { return }
+    if !json.Valid(v) {
+        jw.err = fmt.Errorf("Invalid JSON %q.", truncate(v, 32))
+        return
+    }
+
+    buf := &bytes.Buffer{}
+    switch {
+    case indent != "":
+        json.Indent(buf, v, prefix, indent)
+    case compact:
+        json.Compact(buf, v)
+    default:
+        buf.Write(v)
+    }
+    jw.write(buf.Bytes())
+}
+
+// writeJSONString writes s as quoted JSON string. Invalid UTF-8 is replaced
+// by U+FFFD.
+func writeJSONString(buf *bytes.Buffer, s string) {
+    buf.WriteByte('"')
+    for i := 0; i < len(s); {
+        r, size := utf8.DecodeRuneInString(s[i:])
+        i += size
+        switch {
+        case r == '"' || r == '\\':
+            buf.WriteByte('\\')
+            buf.WriteRune(r)
+        case r == '\n':
+            buf.WriteString(`\n`)
+        case r == '\r':
+            buf.WriteString(`\r`)
+        case r == '\t':
+            buf.WriteString(`\t`)
+        case r < 0x20, r == '\u2028', r == '\u2029':
+            buf.WriteString(`\u`)
+            for shift := uint(12); ; shift -= 4 {
+                buf.WriteByte(hexDigits[(r>>shift)&0xf])
+                if shift == 0 { break }
+            }
+        case r == utf8.RuneError && size == 1:
+            buf.WriteString(`\ufffd`)
+        default:
+            buf.WriteRune(r)
+        }
+    }
+    buf.WriteByte('"')
+}
+
+func truncate(p []byte, n int) []byte {
+    if len(p) <= n { return p }
+    return p[:n]
+}
@@ -0,0 +1,153 @@
+package vesupro
+
+import (
+    "bytes"
+    "fmt"
+    "io"
+)
+
+// EvalLimits bounds the resources a program may use. They are enforced while
+// scanning and parsing, i.e., before any method is dispatched. Strings and
+// JSON values are abandoned as soon as they exceed MaxStringLength,
+// MaxJSONDepth or MaxJSONLength, so that they cannot exhaust memory even
+// without MaxBytes. Identifiers, numbers and whitespace are bounded by
+// MaxBytes only. Definitions passed to EvaluateDefinitions, e.g. with bound
+// variables, are checked before they are evaluated. A zero value disables
+// the respective limit; a nil *EvalLimits disables all of them.
+type EvalLimits struct {
+    MaxBytes int        // size of the program
+    MaxDefinitions int  // number of definitions
+    MaxChainLength int  // number of method calls per definition
+    MaxArguments int    // number of arguments per method call
+    MaxStringLength int // size of a string, including quotes
+    MaxJSONDepth int    // nesting depth of a JSON argument
+    MaxJSONLength int   // size of a JSON argument
+}
+
+// LimitError is returned if a program exceeds one of the EvalLimits.
+type LimitError struct {
+    Limit string // name of the exceeded limit, e.g. "MaxBytes"
+    Max int
+    RuneOffset int
+}
+
+func (e *LimitError) Error() string {
+    return fmt.Sprintf("Limit %s of %d exceeded. (rune pos. %d)", e.Limit,
+        e.Max, e.RuneOffset)
+}
+
+func (l *EvalLimits) check(limit string, max int, n int,
+t Tokenizer) error {
+    if max <= 0 || n <= max { return nil }
+    return l.exceeded(limit, max, t)
+}
+
+func (l *EvalLimits) exceeded(limit string, max int, t Tokenizer) error {
+    offset := 0
+    if t != nil {
+        offset = t.RuneOffset()
+    }
+    return &LimitError{Limit: limit, Max: max, RuneOffset: offset}
+}
+
+// readProgram reads at most MaxBytes bytes from program.
+func (l *EvalLimits) readProgram(program io.Reader) (io.Reader, error) {
+    if l == nil || l.MaxBytes <= 0 { return program, nil }
+
+    if buf, isBytesBuffer := program.(*bytes.Buffer); isBytesBuffer {
+        return buf, l.check("MaxBytes", l.MaxBytes, buf.Len(), nil)
+    }
+    buf := &bytes.Buffer{}
+    _, err := buf.ReadFrom(io.LimitReader(program, int64(l.MaxBytes)+1))
+    if err != nil { return nil, &readError{err} }
+    return buf, l.check("MaxBytes", l.MaxBytes, buf.Len(), nil)
+}
+
+func (l *EvalLimits) checkDefinitions(n int, t Tokenizer) error {
+    if l == nil { return nil }
+    return l.check("MaxDefinitions", l.MaxDefinitions, n, t)
+}
+
+func (l *EvalLimits) checkChainLength(n int, t Tokenizer) error {
+    if l == nil { return nil }
+    return l.check("MaxChainLength", l.MaxChainLength, n, t)
+}
+
+func (l *EvalLimits) checkArguments(n int, t Tokenizer) error {
+    if l == nil { return nil }
+    return l.check("MaxArguments", l.MaxArguments, n, t)
+}
+
+// scanString scans a string, which fails as soon as it is longer than
+// MaxStringLength.
+func (l *EvalLimits) scanString(t Tokenizer) (Token, error) {
+    if l == nil {
+        tok, _ := scanString(t, 0)
+        return tok, nil
+    }
+    tok, exceeded := scanString(t, l.MaxStringLength)
+    if exceeded {
+        return tok, l.exceeded("MaxStringLength", l.MaxStringLength, t)
+    }
+    return tok, nil
+}
+
+// scanJSON scans a JSON object or array, which fails as soon as it exceeds
+// MaxJSONDepth or MaxJSONLength, or a string within it MaxStringLength.
+func (l *EvalLimits) scanJSON(t Tokenizer) (Token, error) {
+    tok, exceeded := scanJSON(t, l)
+    if exceeded != "" {
+        return tok, l.exceeded(exceeded, l.jsonLimit(exceeded), t)
+    }
+    return tok, nil
+}
+
+// jsonLimit returns the value of a limit reported by scanJSON.
+func (l *EvalLimits) jsonLimit(limit string) int {
+    switch limit {
+    case "MaxJSONDepth":
+        return l.MaxJSONDepth
+    case "MaxJSONLength":
+        return l.MaxJSONLength
+    }
+    return l.MaxStringLength
+}
+
+// checkArgument checks the size of string and the depth of JSON arguments
+// which have not been scanned with the limits, e.g. bound variables.
+func (l *EvalLimits) checkArgument(arg *ArgumentToken, t Tokenizer) error {
+    if l == nil { return nil }
+    switch arg.TokenType {
+    case STRING:
+        return l.check("MaxStringLength", l.MaxStringLength,
+            len(arg.TokenContent), t)
+    case JSON:
+        // scanned again like the JSON arguments of programs
+        jt := NewTokenizer(bytes.NewBuffer(arg.TokenContent))
+        jt.StartToken()
+        jt.Read()
+        if _, exceeded := scanJSON(jt, l); exceeded != "" {
+            return l.exceeded(exceeded, l.jsonLimit(exceeded), t)
+        }
+    }
+    return nil
+}
+
+// checkParsed checks parsed definitions, e.g. those of a PreparedQuery
+// with its variables bound, against all limits but MaxBytes.
+func (l *EvalLimits) checkParsed(defs []*Definition) error {
+    if l == nil { return nil }
+    if err := l.checkDefinitions(len(defs), nil); err != nil { return err }
+    for _, def := range defs {
+        err := l.checkChainLength(len(def.MethodCalls), nil)
+        if err != nil { return err }
+        for _, call := range def.MethodCalls {
+            err := l.checkArguments(len(call.Arguments), nil)
+            if err != nil { return err }
+            for _, arg := range call.Arguments {
+                if err := l.checkArgument(arg, nil); err != nil { return err }
+            }
+        }
+    }
+    return nil
+}
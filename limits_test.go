@@ -0,0 +1,143 @@
+package vesupro_test
+
+import (
+    "./"
+    "testing"
+    "bytes"
+    "io"
+    "strings"
+)
+
+type countingObject struct {
+    dispatched int
+}
+
+func (c *countingObject) Dispatch(mc *vesupro.MethodCall) (vesupro.VesuproObject, error) {
+    c.dispatched++
+    return c, nil
+}
+
+func (c *countingObject) MarshalJSON() ([]byte, error) {
+    return []byte("null"), nil
+}
+
+func TestEvalLimits(t *testing.T) {
+    tests := []struct {
+        in string
+        limits vesupro.EvalLimits
+        limit string
+    }{
+        {in: `v1 := obj.a(); v2 := obj.b();`,
+        limits: vesupro.EvalLimits{MaxBytes: 29}},
+        {in: `v1 := obj.a(); v2 := obj.b();`,
+        limits: vesupro.EvalLimits{MaxBytes: 28}, limit: "MaxBytes"},
+        {in: `v1 := obj.a(); v2 := obj.b(); v3 := obj.c();`,
+        limits: vesupro.EvalLimits{MaxDefinitions: 2}, limit: "MaxDefinitions"},
+        {in: `v1 := obj.a().b().c();`,
+        limits: vesupro.EvalLimits{MaxChainLength: 3}},
+        {in: `v1 := obj.a().b().c().d();`,
+        limits: vesupro.EvalLimits{MaxChainLength: 3}, limit: "MaxChainLength"},
+        {in: `v1 := obj.a(1, 2, 3);`,
+        limits: vesupro.EvalLimits{MaxArguments: 2}, limit: "MaxArguments"},
+        {in: `v1 := obj.a("abcd");`,
+        limits: vesupro.EvalLimits{MaxStringLength: 6}},
+        {in: `v1 := obj.a("abcde");`,
+        limits: vesupro.EvalLimits{MaxStringLength: 6}, limit: "MaxStringLength"},
+        {in: `v1 := obj.a({"a": [{"b": "{{{{"}]});`,
+        limits: vesupro.EvalLimits{MaxJSONDepth: 3}},
+        {in: `v1 := obj.a({"a": [{"b": [1]}]});`,
+        limits: vesupro.EvalLimits{MaxJSONDepth: 3}, limit: "MaxJSONDepth"},
+        {in: `v1 := obj.a({"a": [1, 2]});`,
+        limits: vesupro.EvalLimits{MaxJSONLength: 13}},
+        {in: `v1 := obj.a({"a": [1, 22]});`,
+        limits: vesupro.EvalLimits{MaxJSONLength: 13}, limit: "MaxJSONLength"},
+        {in: `v1 := obj.a({"a": "abcde"});`,
+        limits: vesupro.EvalLimits{MaxStringLength: 6},
+        limit: "MaxStringLength"},
+    }
+
+    for i, tt := range tests {
+        obj := &countingObject{}
+        ev := vesupro.NewEvaluator(map[string]vesupro.VesuproObject{"obj": obj})
+        ev.Limits = &tt.limits
+
+        // exercise both tokenizers
+        for _, in := range []interface{ Read([]byte) (int, error) }{
+            bytes.NewBufferString(tt.in), strings.NewReader(tt.in),
+        } {
+            err := ev.Evaluate(&bytes.Buffer{}, in)
+            limitErr, isLimitErr := err.(*vesupro.LimitError)
+            switch {
+            case tt.limit == "" && err != nil:
+                t.Errorf("%d. error: %q", i, err)
+            case tt.limit != "" && !isLimitErr:
+                t.Errorf("%d. expected LimitError, got %q", i, err)
+            case tt.limit != "" && limitErr.Limit != tt.limit:
+                t.Errorf("%d. limit mismatch %q != %q.", i, tt.limit,
+                limitErr.Limit)
+            case tt.limit != "" && obj.dispatched != 0:
+                t.Errorf("%d. dispatched despite exceeded limit.", i)
+            }
+        }
+    }
+}
+
+// endless repeats a byte forever.
+type endless byte
+
+func (e endless) Read(p []byte) (int, error) {
+    for i := range p {
+        p[i] = byte(e)
+    }
+    return len(p), nil
+}
+
+func TestEvalLimits_Scanning(t *testing.T) {
+    // without MaxBytes, the scanner has to stop at the exceeded limit
+    tests := []struct {
+        prefix string
+        fill byte
+        limits vesupro.EvalLimits
+        limit string
+    }{
+        {prefix: `v1 := obj.a("`, fill: 'a',
+        limits: vesupro.EvalLimits{MaxStringLength: 64},
+        limit: "MaxStringLength"},
+        {prefix: `v1 := obj.a(`, fill: '[',
+        limits: vesupro.EvalLimits{MaxJSONDepth: 64}, limit: "MaxJSONDepth"},
+        // strings in any position
+        {prefix: `"`, fill: 'a',
+        limits: vesupro.EvalLimits{MaxStringLength: 64},
+        limit: "MaxStringLength"},
+        {prefix: `v1 := obj.a(1 "`, fill: 'a',
+        limits: vesupro.EvalLimits{MaxStringLength: 64},
+        limit: "MaxStringLength"},
+        {prefix: `v1 := obj.a(1 `, fill: '[',
+        limits: vesupro.EvalLimits{MaxJSONDepth: 4}, limit: "MaxJSONDepth"},
+        {prefix: `v1 := obj.a({"x": "`, fill: 'a',
+        limits: vesupro.EvalLimits{MaxStringLength: 64, MaxJSONDepth: 4},
+        limit: "MaxStringLength"},
+        {prefix: `v1 := obj.a({"x": "`, fill: 'a',
+        limits: vesupro.EvalLimits{MaxJSONLength: 64},
+        limit: "MaxJSONLength"},
+        {prefix: `v1 := obj.a([1`, fill: ',',
+        limits: vesupro.EvalLimits{MaxJSONLength: 64},
+        limit: "MaxJSONLength"},
+    }
+
+    for i, tt := range tests {
+        ev := vesupro.NewEvaluator(map[string]vesupro.VesuproObject{
+            "obj": &countingObject{}})
+        ev.Limits = &tt.limits
+
+        in := io.MultiReader(strings.NewReader(tt.prefix), endless(tt.fill))
+        err := ev.Evaluate(&bytes.Buffer{}, in)
+        limitErr, isLimitErr := err.(*vesupro.LimitError)
+        if !isLimitErr {
+            t.Errorf("%d. expected LimitError, got %q", i, err)
+        } else if limitErr.Limit != tt.limit {
+            t.Errorf("%d. limit mismatch %q != %q.", i, tt.limit,
+            limitErr.Limit)
+        }
+    }
+}
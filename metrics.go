@@ -0,0 +1,105 @@
+package vesupro
+
+import (
+    "context"
+    "io"
+    "time"
+)
+
+// OtherLabel replaces method names which are not part of the API in metric
+// labels, so that arbitrary programs cannot blow up their cardinality.
+const OtherLabel = "other"
+
+// Metrics receives measurements of the evaluator. Receiver labels are
+// bounded by the symbol table, method labels by the API of the evaluator.
+type Metrics interface {
+    // ObserveProgram is called once for every program. definitions is 0 if
+    // the program could not be parsed.
+    ObserveProgram(definitions int, outputBytes int64, err error)
+    // ObserveParseFailure is called for programs which are rejected by the
+    // scanner or parser. kind is "syntax", "read" or the name of an
+    // exceeded limit, e.g. "MaxBytes".
+    ObserveParseFailure(kind string)
+    // ObserveDispatch is called for every dispatched method call. Batched
+    // calls are observed individually with the duration of their batch.
+    ObserveDispatch(receiver string, method string, d time.Duration,
+        err error)
+}
+
+// ParseErrorKind classifies errors of the scanner and parser.
+func ParseErrorKind(err error) string {
+    switch err := err.(type) {
+    case *LimitError:
+        return err.Limit
+    case *readError:
+        return "read"
+    }
+    return "syntax"
+}
+
+// readError is an error while reading the program.
+type readError struct {
+    err error
+}
+
+func (e *readError) Error() string { return e.err.Error() }
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+    w io.Writer
+    n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+    n, err := c.w.Write(p)
+    c.n += int64(n)
+    return n, err
+}
+
+// metricsInterceptor measures the latency of every Dispatch and
+// BatchDispatch.
+func metricsInterceptor(metrics Metrics,
+methods map[string]bool) Interceptor {
+    label := func(method string) string {
+        if !methods[method] { return OtherLabel }
+        return method
+    }
+    return func(ctx context.Context, call *Call,
+    next Invoker) (VesuproObject, error) {
+        switch call.Kind {
+        case DispatchCall:
+            start := time.Now()
+            obj, err := next(ctx, call)
+            metrics.ObserveDispatch(call.Receiver, label(call.MethodCall.Name),
+                time.Since(start), err)
+            return obj, err
+        case BatchDispatchCall:
+            start := time.Now()
+            obj, err := next(ctx, call)
+            d := time.Since(start)
+            result, _ := obj.(*BatchResult)
+            for i, mc := range call.Batch {
+                callErr := err
+                if err == nil && result != nil && i < len(result.Errors) {
+                    callErr = result.Errors[i]
+                }
+                metrics.ObserveDispatch(call.Receiver, label(mc.Name), d,
+                    callErr)
+            }
+            return obj, err
+        }
+        return next(ctx, call)
+    }
+}
+
+// apiMethods returns the wire names of all methods of the evaluator's API.
+func (e *Evaluator) apiMethods() map[string]bool {
+    methods := make(map[string]bool)
+    if e.API == nil { return methods }
+    for _, typeMethods := range e.API.Methods {
+        for _, method := range typeMethods {
+            methods[method.WireName()] = true
+        }
+    }
+    return methods
+}
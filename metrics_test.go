@@ -0,0 +1,70 @@
+package vesupro_test
+
+import (
+    "./"
+    "./apidistiller"
+    "testing"
+    "bytes"
+    "fmt"
+    "reflect"
+    "time"
+)
+
+type recordingMetrics struct {
+    events []string
+}
+
+func (m *recordingMetrics) ObserveProgram(definitions int, outputBytes int64,
+err error) {
+    m.events = append(m.events, fmt.Sprintf("program %d %d %v", definitions,
+        outputBytes, err != nil))
+}
+
+func (m *recordingMetrics) ObserveParseFailure(kind string) {
+    m.events = append(m.events, "parse "+kind)
+}
+
+func (m *recordingMetrics) ObserveDispatch(receiver string, method string,
+d time.Duration, err error) {
+    m.events = append(m.events, fmt.Sprintf("dispatch %s.%s %v", receiver,
+        method, err != nil))
+}
+
+func TestEvaluator_Metrics(t *testing.T) {
+    api := apidistiller.NewAPI("users")
+    api.Methods["Users"] = []*apidistiller.Method{
+        &apidistiller.Method{Name: "Get"},
+    }
+
+    tests := []struct {
+        in string
+        events []string
+    }{
+        {in: `v1 := users.get(1).bogus(); v2 := flaky.get();`,
+        events: []string{
+            "dispatch users.get false", "dispatch users.other false",
+            "dispatch flaky.get true", "program 2 10 true"}},
+        {in: `v1 := users.get(1)`,
+        events: []string{"parse syntax", "program 0 0 true"}},
+        {in: `v1 := users.get(1, 2, 3);`,
+        events: []string{"parse MaxArguments", "program 0 0 true"}},
+        {in: `v1 := users.get();`,
+        events: []string{"dispatch users.get false", "program 1 11 false"}},
+    }
+
+    for i, tt := range tests {
+        metrics := &recordingMetrics{}
+        ev := vesupro.NewEvaluator(map[string]vesupro.VesuproObject{
+            "users": &Users{}, "flaky": &flakyObject{},
+        })
+        ev.Limits = &vesupro.EvalLimits{MaxArguments: 2}
+        ev.Metrics = metrics
+        ev.API = api
+
+        ev.Evaluate(&bytes.Buffer{}, bytes.NewBufferString(tt.in))
+        if !reflect.DeepEqual(tt.events, metrics.events) {
+            t.Errorf("%d. events mismatch %q != %q.", i, tt.events,
+            metrics.events)
+        }
+    }
+}
@@ -0,0 +1,65 @@
+// Package otelvesupro adapts OpenTelemetry tracers to vesupro.Tracer.
+package otelvesupro
+
+import (
+    ".."
+    "context"
+    "fmt"
+
+    "go.opentelemetry.io/otel/attribute"
+    "go.opentelemetry.io/otel/codes"
+    "go.opentelemetry.io/otel/trace"
+)
+
+// InstrumentationName is the name of the tracer obtained from the provider.
+const InstrumentationName = "github.com/d-s-d/vesupro"
+
+// Tracer emits the spans of the evaluator as OpenTelemetry spans.
+type Tracer struct {
+    tracer trace.Tracer
+}
+
+// NewTracer creates a tracer using tp.
+func NewTracer(tp trace.TracerProvider) *Tracer {
+    return &Tracer{tracer: tp.Tracer(InstrumentationName)}
+}
+
+func (t *Tracer) StartSpan(ctx context.Context, name string,
+attrs ...vesupro.Attribute) (context.Context, vesupro.Span) {
+    ctx, span := t.tracer.Start(ctx, name,
+        trace.WithAttributes(convertAttributes(attrs)...))
+    return ctx, &otelSpan{span: span}
+}
+
+type otelSpan struct {
+    span trace.Span
+}
+
+func (s *otelSpan) SetAttributes(attrs ...vesupro.Attribute) {
+    s.span.SetAttributes(convertAttributes(attrs)...)
+}
+
+func (s *otelSpan) End(err error) {
+    if err != nil {
+        s.span.RecordError(err)
+        s.span.SetStatus(codes.Error, err.Error())
+    }
+    s.span.End()
+}
+
+func convertAttributes(attrs []vesupro.Attribute) []attribute.KeyValue {
+    kvs := make([]attribute.KeyValue, 0, len(attrs))
+    for _, attr := range attrs {
+        switch v := attr.Value.(type) {
+        case string:
+            kvs = append(kvs, attribute.String(attr.Key, v))
+        case int:
+            kvs = append(kvs, attribute.Int(attr.Key, v))
+        case bool:
+            kvs = append(kvs, attribute.Bool(attr.Key, v))
+        default:
+            kvs = append(kvs, attribute.String(attr.Key, fmt.Sprint(v)))
+        }
+    }
+    return kvs
+}
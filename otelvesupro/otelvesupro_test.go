@@ -0,0 +1,80 @@
+package otelvesupro_test
+
+import (
+    "./"
+    ".."
+    "testing"
+    "bytes"
+    "context"
+
+    "go.opentelemetry.io/otel/attribute"
+    "go.opentelemetry.io/otel/codes"
+    sdktrace "go.opentelemetry.io/otel/sdk/trace"
+    "go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+type object struct{}
+
+func (o *object) Dispatch(mc *vesupro.MethodCall) (vesupro.VesuproObject, error) {
+    if mc.Name == "fail" {
+        return nil, context.DeadlineExceeded
+    }
+    return o, nil
+}
+
+func (o *object) MarshalJSON() ([]byte, error) {
+    return []byte("{}"), nil
+}
+
+func TestTracer(t *testing.T) {
+    exporter := tracetest.NewInMemoryExporter()
+    tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+    ev := vesupro.NewEvaluator(map[string]vesupro.VesuproObject{
+        "obj": &object{},
+    })
+    ev.Tracer = otelvesupro.NewTracer(tp)
+    ev.Evaluate(&bytes.Buffer{},
+        bytes.NewBufferString(`v1 := obj.get(1); v2 := obj.fail();`))
+
+    spans := exporter.GetSpans()
+    names := make([]string, 0, len(spans))
+    byName := make(map[string]tracetest.SpanStub)
+    for _, span := range spans {
+        names = append(names, span.Name)
+        byName[span.Name] = span
+    }
+    exp := []string{
+        vesupro.DispatchSpan, vesupro.MarshalSpan, vesupro.DefinitionSpan,
+        vesupro.DispatchSpan, vesupro.DefinitionSpan, vesupro.ProgramSpan,
+    }
+    if len(exp) != len(names) {
+        t.Fatalf("span mismatch %q != %q.", exp, names)
+    }
+    for i := range exp {
+        if exp[i] != names[i] {
+            t.Fatalf("span mismatch %q != %q.", exp, names)
+        }
+    }
+
+    program := byName[vesupro.ProgramSpan]
+    if program.Status.Code != codes.Error {
+        t.Errorf("program span status %v, expected error.", program.Status)
+    }
+    dispatch := spans[0]
+    if dispatch.Parent.SpanID() != spans[2].SpanContext.SpanID() {
+        t.Errorf("dispatch span is no child of the definition span.")
+    }
+    if spans[2].Parent.SpanID() != program.SpanContext.SpanID() {
+        t.Errorf("definition span is no child of the program span.")
+    }
+    found := false
+    for _, kv := range dispatch.Attributes {
+        if kv == attribute.Int(vesupro.ArgumentsAttribute, 1) {
+            found = true
+        }
+    }
+    if !found {
+        t.Errorf("argument count missing in %v.", dispatch.Attributes)
+    }
+}
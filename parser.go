@@ -1,8 +1,12 @@
 package vesupro
 
 import (
+    "encoding"
+    "encoding/json"
     "fmt"
     "strconv"
+    "strings"
+    "time"
 )
 
 const initMethodCall = 4
@@ -10,6 +14,8 @@ const initMethodCall = 4
 type ArgumentToken struct {
     TokenType Token
     TokenContent []byte
+    // Name is set for named arguments, e.g. `limit: 10`.
+    Name string
 }
 
 func (arg *ArgumentToken) ToInt64() (int64, error) {
@@ -21,6 +27,38 @@ func (arg *ArgumentToken) ToInt64() (int64, error) {
     return strconv.ParseInt(string(arg.TokenContent), 10, 64)
 }
 
+// ToIntN is like ToInt64 for an integer type of bitSize bits, 0 for int.
+// It fails for values which do not fit.
+func (arg *ArgumentToken) ToIntN(bitSize int) (int64, error) {
+    if arg.TokenType != INT {
+        return 0, fmt.Errorf(
+            "ToIntN(): Cannot convert Token of type %d to integer.",
+            arg.TokenType)
+    }
+    i, err := strconv.ParseInt(string(arg.TokenContent), 10, bitSize)
+    if err != nil {
+        return 0, fmt.Errorf("ToIntN(): %s is out of range.",
+            arg.TokenContent)
+    }
+    return i, nil
+}
+
+// ToUintN is like ToIntN for an unsigned integer type, 0 for uint. It fails
+// for negative values, too.
+func (arg *ArgumentToken) ToUintN(bitSize int) (uint64, error) {
+    if arg.TokenType != INT {
+        return 0, fmt.Errorf(
+            "ToUintN(): Cannot convert Token of type %d to integer.",
+            arg.TokenType)
+    }
+    u, err := strconv.ParseUint(string(arg.TokenContent), 10, bitSize)
+    if err != nil {
+        return 0, fmt.Errorf("ToUintN(): %s is out of range.",
+            arg.TokenContent)
+    }
+    return u, nil
+}
+
 func (arg *ArgumentToken) ToFloat64() (float64, error) {
     if arg.TokenType != FLOAT {
         return 0.0, fmt.Errorf(
@@ -39,6 +77,18 @@ func (arg *ArgumentToken) ToBool() (bool, error) {
     return arg.TokenType == TRUE, nil
 }
 
+// Unmarshal decodes the argument into v like json.Unmarshal. It is used for
+// slices, which are passed as JSON arrays, and structs.
+func (arg *ArgumentToken) Unmarshal(v interface{}) error {
+    switch arg.TokenType {
+    case INT, FLOAT, STRING, TRUE, FALSE, JSON:
+    default:
+        return fmt.Errorf(
+            "Unmarshal(): Cannot decode Token of type %d.", arg.TokenType)
+    }
+    return json.Unmarshal(arg.TokenContent, v)
+}
+
 func (arg *ArgumentToken) ToString() (string, error) {
     if arg.TokenType != STRING {
         return "", fmt.Errorf(
@@ -48,6 +98,68 @@ func (arg *ArgumentToken) ToString() (string, error) {
     return string(arg.TokenContent), nil
 }
 
+// Unquote returns the content of a STRING argument, e.g. `a"b` for
+// `"a\"b"`, while ToString returns the literal as it is.
+func (arg *ArgumentToken) Unquote() (string, error) {
+    return arg.text("Unquote")
+}
+
+// text returns the unquoted content of a STRING argument.
+func (arg *ArgumentToken) text(method string) (string, error) {
+    if arg.TokenType != STRING {
+        return "", fmt.Errorf(
+            "%s(): Cannot convert Token of type %d to string.",
+            method, arg.TokenType)
+    }
+    var s string
+    if err := json.Unmarshal(arg.TokenContent, &s); err != nil {
+        return "", fmt.Errorf("%s(): Invalid string %s.", method,
+            arg.TokenContent)
+    }
+    return s, nil
+}
+
+// ToStringMap decodes a JSON object of strings, e.g. `{"lang": "en"}`.
+func (arg *ArgumentToken) ToStringMap() (map[string]string, error) {
+    if arg.TokenType != JSON || len(arg.TokenContent) == 0 ||
+    arg.TokenContent[0] != '{' {
+        return nil, fmt.Errorf(
+            "ToStringMap(): Cannot convert Token of type %d to map.",
+            arg.TokenType)
+    }
+    var m map[string]string
+    if err := json.Unmarshal(arg.TokenContent, &m); err != nil {
+        return nil, fmt.Errorf(
+            "ToStringMap(): %s is not an object of strings.",
+            arg.TokenContent)
+    }
+    return m, nil
+}
+
+// ToTime parses a STRING argument in RFC 3339 format, e.g.
+// "2006-01-02T15:04:05Z".
+func (arg *ArgumentToken) ToTime() (time.Time, error) {
+    s, err := arg.text("ToTime")
+    if err != nil { return time.Time{}, err }
+    t, err := time.Parse(time.RFC3339, s)
+    if err != nil {
+        return time.Time{}, fmt.Errorf(
+            "ToTime(): %s is not an RFC 3339 time.", arg.TokenContent)
+    }
+    return t, nil
+}
+
+// ToText decodes a STRING argument into v, e.g. a custom id type.
+func (arg *ArgumentToken) ToText(v encoding.TextUnmarshaler) error {
+    s, err := arg.text("ToText")
+    if err != nil { return err }
+    if err := v.UnmarshalText([]byte(s)); err != nil {
+        return fmt.Errorf("ToText(): Cannot unmarshal %s: %s.",
+            arg.TokenContent, strings.TrimSuffix(err.Error(), "."))
+    }
+    return nil
+}
+
 
 type MethodCall struct {
     Name string
@@ -78,30 +190,62 @@ func NewDefinition(targetName string, rcvName string,
 }
 
 func ParseArgumentList(t Tokenizer) ([]*ArgumentToken, error) {
+    return parseArgumentList(t, nil)
+}
 
-    tok := Scan(t, true)
+func parseArgumentList(t Tokenizer,
+limits *EvalLimits) ([]*ArgumentToken, error) {
+
+    tok, err := scan(t, true, limits)
+    if err != nil { return nil, err }
 
     if tok == CLOSE_PAREN {
         return []*ArgumentToken{}, nil
     }
 
     args := make([]*ArgumentToken, 0, 8)
+    names := make(map[string]bool)
 
     for {
+        var name string
+        if tok == IDENT {
+            name = string(t.CurrentToken())
+            if names[name] {
+                return nil, fmt.Errorf(
+                    "Argument %s given twice. (rune pos. %d)", name,
+                    t.RuneOffset())
+            }
+            names[name] = true
+            if err := scanExpTok(t, COLON, true, limits); err != nil {
+                return nil, err
+            }
+            tok, err = scan(t, true, limits)
+            if err != nil { return nil, err }
+        } else if len(names) > 0 {
+            return nil, fmt.Errorf(
+                "Positional argument after named argument. (rune pos. %d)",
+                t.RuneOffset())
+        }
+
         switch tok {
-        case INT, FLOAT, STRING, TRUE, FALSE, JSON:
+        case INT, FLOAT, STRING, TRUE, FALSE, JSON, VAR:
         default:
             return nil, fmt.Errorf(
                 "Expected argument token, got %d. (rune pos. %d)", tok,
                 t.RuneOffset())
         }
         args = append(args, &ArgumentToken{
-            TokenType: tok, TokenContent: t.CurrentToken()})
+            TokenType: tok, TokenContent: t.CurrentToken(), Name: name})
+        if err := limits.checkArguments(len(args), t); err != nil {
+            return nil, err
+        }
 
-        tok = Scan(t, true)
+        tok, err = scan(t, true, limits)
+        if err != nil { return nil, err }
         switch tok {
         case COMMA:
-            tok = Scan(t, true)
+            tok, err = scan(t, true, limits)
+            if err != nil { return nil, err }
         case CLOSE_PAREN:
             return args, nil
         default:
@@ -113,19 +257,32 @@ func ParseArgumentList(t Tokenizer) ([]*ArgumentToken, error) {
 }
 
 func ParseDefinitions(t Tokenizer) ([]*Definition, error) {
+    return ParseDefinitionsWithLimits(t, nil)
+}
+
+// ParseDefinitionsWithLimits parses all definitions and fails with a
+// *LimitError as soon as one of the limits is exceeded.
+func ParseDefinitionsWithLimits(t Tokenizer,
+limits *EvalLimits) ([]*Definition, error) {
     var err error
 
     defs := make([]*Definition, 0, 2)
-    def, err := ParseDefinition(t)
-    for ;err == nil && def != nil; def, err = ParseDefinition(t) {
+    def, err := parseDefinition(t, limits)
+    for ;err == nil && def != nil; def, err = parseDefinition(t, limits) {
         defs = append(defs, def)
+        err = limits.checkDefinitions(len(defs), t)
+        if err != nil { return nil, err }
     }
     return defs, err
 }
 
 func ParseDefinition(t Tokenizer) (*Definition, error) {
-    var err error
-    tok := Scan(t, true)
+    return parseDefinition(t, nil)
+}
+
+func parseDefinition(t Tokenizer, limits *EvalLimits) (*Definition, error) {
+    tok, err := scan(t, true, limits)
+    if err != nil { return nil, err }
 
     if tok == EOF { return nil, nil }
 
@@ -137,34 +294,35 @@ func ParseDefinition(t Tokenizer) (*Definition, error) {
 
     // targetName := rcvName.{funcName([Argument [{, Argument}])}
     //            ^^
-    err = ScanExpTok(t, DEF_OP, true)
+    err = scanExpTok(t, DEF_OP, true, limits)
     if err != nil { return nil, err }
 
     // targetName := rcvName.{funcName([Argument [{, Argument}])}
     //               ^     ^
-    err = ScanExpTok(t, IDENT, true)
+    err = scanExpTok(t, IDENT, true, limits)
     if err != nil { return nil, err }
     rcvName := string(t.CurrentToken())
 
     // targetName := rcvName.funcName([Argument [{, Argument}])}
     //                      ^
-    err = ScanExpTok(t, DOT, true)
+    err = scanExpTok(t, DOT, true, limits)
     if err != nil { return nil, err }
 
-    err = ScanExpTok(t, IDENT, true)
+    err = scanExpTok(t, IDENT, true, limits)
     if err != nil { return nil, err }
     curMethodCall := NewMethodCall(string(t.CurrentToken()))
     methodCalls = append(methodCalls, curMethodCall)
 
     for {
-        err = ScanExpTok(t, OPEN_PAREN, true)
+        err = scanExpTok(t, OPEN_PAREN, true, limits)
         if err != nil { return nil, err }
 
 
-        curMethodCall.Arguments, err = ParseArgumentList(t)
+        curMethodCall.Arguments, err = parseArgumentList(t, limits)
         if err != nil { return nil, err }
 
-        tok = Scan(t, true)
+        tok, err = scan(t, true, limits)
+        if err != nil { return nil, err }
         if tok != DOT {
             if tok == SEMI {
                 return NewDefinition(targetName, rcvName, methodCalls), nil
@@ -173,9 +331,11 @@ func ParseDefinition(t Tokenizer) (*Definition, error) {
                 "Expected DOT or SEMI, but got %d.", tok)
         }
 
-        err = ScanExpTok(t, IDENT, true)
+        err = scanExpTok(t, IDENT, true, limits)
         if err != nil { return nil, err }
         curMethodCall = NewMethodCall(string(t.CurrentToken() ))
         methodCalls = append(methodCalls, curMethodCall)
+        err = limits.checkChainLength(len(methodCalls), t)
+        if err != nil { return nil, err }
     }
 }
@@ -28,6 +28,27 @@ func TestParseDefinition(t *testing.T) {
             },
         },
     },
+    {in: `v1 := search.run("x", limit: 10);`,
+     out: []*vesupro.Definition{&vesupro.Definition{
+            TargetName: "v1",
+            ReceiverName: "search",
+            MethodCalls: []*vesupro.MethodCall{&vesupro.MethodCall{
+                Name: "run",
+                Arguments: []*vesupro.ArgumentToken {
+                    &vesupro.ArgumentToken{
+                        TokenType: vesupro.STRING,
+                        TokenContent: []byte(`"x"`),
+                    },
+                    &vesupro.ArgumentToken{
+                        TokenType: vesupro.INT,
+                        TokenContent: []byte("10"),
+                        Name: "limit",
+                    },
+                },
+            }},
+        },
+    },
+    },
     }
 
     for i, tt := range tests {
@@ -49,3 +70,25 @@ func TestParseDefinition(t *testing.T) {
         }
     }
 }
+
+func TestParseDefinition_NamedArgumentErrors(t *testing.T) {
+    tests := []struct {
+        in string
+        err string
+    }{
+        {in: `v1 := search.run(limit: 10, "x");`,
+        err: "Positional argument after named argument. (rune pos. 31)"},
+        {in: `v1 := search.run(limit: 10, limit: 5);`,
+        err: "Argument limit given twice. (rune pos. 33)"},
+        {in: `v1 := search.run(limit 10);`,
+        err: "Expected token id 20, got 7. (Rune pos.: 25)"},
+    }
+
+    for i, tt := range tests {
+        tokzr := vesupro.NewTokenizer(bytes.NewBufferString(tt.in))
+        _, err := vesupro.ParseDefinitions(tokzr)
+        if err == nil || err.Error() != tt.err {
+            t.Errorf("%d. error mismatch %q != %v.", i, tt.err, err)
+        }
+    }
+}
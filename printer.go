@@ -0,0 +1,101 @@
+package vesupro
+
+import (
+    "bytes"
+    "fmt"
+    "io"
+)
+
+// Printer turns definitions back into program text.
+type Printer struct {
+    // LineWidth is the width after which a chain of method calls is wrapped
+    // onto multiple lines, one call per line. Zero disables wrapping.
+    LineWidth int
+    // Indent is used for wrapped method calls.
+    Indent string
+}
+
+// Print writes the canonical form of defs to w: one definition per line,
+// a single space around the definition operator, after each comma and after
+// the colon of named arguments.
+func Print(w io.Writer, defs []*Definition) error {
+    p := &Printer{}
+    return p.Print(w, defs)
+}
+
+// Print writes defs to w, wrapping chains longer than p.LineWidth.
+func (p *Printer) Print(w io.Writer, defs []*Definition) error {
+    buf := &bytes.Buffer{}
+    for _, def := range defs {
+        if err := p.printDefinition(buf, def); err != nil { return err }
+    }
+    _, err := buf.WriteTo(w)
+    return err
+}
+
+func (p *Printer) printDefinition(buf *bytes.Buffer, def *Definition) error {
+    if len(def.MethodCalls) == 0 {
+        return fmt.Errorf("Definition of %q has no method calls.",
+            def.TargetName)
+    }
+
+    start := buf.Len()
+    buf.WriteString(def.TargetName)
+    buf.WriteString(" := ")
+    buf.WriteString(def.ReceiverName)
+
+    calls := make([][]byte, 0, len(def.MethodCalls))
+    width := buf.Len() - start + 1
+    for _, call := range def.MethodCalls {
+        callBuf := &bytes.Buffer{}
+        if err := printMethodCall(callBuf, call); err != nil { return err }
+        calls = append(calls, callBuf.Bytes())
+        width += callBuf.Len()
+    }
+
+    wrap := p.LineWidth > 0 && width > p.LineWidth && len(calls) > 1
+    indent := p.Indent
+    if indent == "" {
+        indent = "    "
+    }
+    for i, call := range calls {
+        if wrap && i > 0 {
+            buf.WriteByte('\n')
+            buf.WriteString(indent)
+        }
+        buf.Write(call)
+    }
+    buf.WriteString(";\n")
+    return nil
+}
+
+func printMethodCall(buf *bytes.Buffer, call *MethodCall) error {
+    buf.WriteByte('.')
+    buf.WriteString(call.Name)
+    buf.WriteByte('(')
+    for i, arg := range call.Arguments {
+        switch arg.TokenType {
+        case INT, FLOAT, STRING, TRUE, FALSE, JSON, VAR:
+        default:
+            return fmt.Errorf("Cannot print argument token of type %d.",
+                arg.TokenType)
+        }
+        if i > 0 {
+            buf.WriteString(", ")
+        }
+        if arg.Name != "" {
+            buf.WriteString(arg.Name)
+            buf.WriteString(": ")
+        }
+        buf.Write(arg.TokenContent)
+    }
+    buf.WriteByte(')')
+    return nil
+}
+
+// Format parses the program read from r and writes it to w using p.
+func (p *Printer) Format(w io.Writer, r io.Reader) error {
+    defs, err := ParseDefinitions(NewTokenizer(r))
+    if err != nil { return err }
+    return p.Print(w, defs)
+}
@@ -0,0 +1,131 @@
+package vesupro_test
+
+import (
+    "./"
+    "testing"
+    "testing/quick"
+    "bytes"
+    "encoding/json"
+    "math/rand"
+    "reflect"
+    "strconv"
+)
+
+func TestPrint(t *testing.T) {
+    tests := []struct {
+        in string
+        out string
+        width int
+    }{
+        {in: `v1:=mockObject.test();`, out: "v1 := mockObject.test();\n"},
+        {in: "v1  :=\n mockObject . foo( 0.1 ,true ).bar();v2 := a.b({ \"a\" : 1 });",
+        out: "v1 := mockObject.foo(0.1, true).bar();\n" +
+            "v2 := a.b({ \"a\" : 1 });\n"},
+        {in: `v1 := mockObject.foo(0.1).bar(true).baz("some string");`,
+        out: "v1 := mockObject.foo(0.1)\n    .bar(true)\n" +
+            "    .baz(\"some string\");\n",
+        width: 40},
+        {in: `v1 := search.run("x" ,limit:10,exact : true);`,
+        out: "v1 := search.run(\"x\", limit: 10, exact: true);\n"},
+        {in: `v1 := mockObject.foo("some rather long string argument");`,
+        out: "v1 := mockObject.foo(\"some rather long string argument\");\n",
+        width: 20},
+    }
+
+    for i, tt := range tests {
+        p := &vesupro.Printer{LineWidth: tt.width}
+        out := &bytes.Buffer{}
+        err := p.Format(out, bytes.NewBufferString(tt.in))
+        if err != nil {
+            t.Errorf("%d. error: %q", i, err)
+        } else if tt.out != out.String() {
+            t.Errorf("%d. in/out mismatch %q != %q.", i, tt.out, out.String())
+        }
+    }
+}
+
+// program is a random but well-formed sequence of definitions.
+type program []*vesupro.Definition
+
+func (program) Generate(r *rand.Rand, size int) reflect.Value {
+    defs := make([]*vesupro.Definition, 1+r.Intn(4))
+    for i := range defs {
+        calls := make([]*vesupro.MethodCall, 1+r.Intn(4))
+        for j := range calls {
+            calls[j] = vesupro.NewMethodCall(randomIdent(r))
+            calls[j].Arguments = make([]*vesupro.ArgumentToken, r.Intn(4))
+            positional := r.Intn(len(calls[j].Arguments) + 1)
+            for k := range calls[j].Arguments {
+                calls[j].Arguments[k] = randomArgument(r)
+                if k >= positional {
+                    // the suffix keeps names unique
+                    calls[j].Arguments[k].Name = randomIdent(r) +
+                        strconv.Itoa(k)
+                }
+            }
+        }
+        defs[i] = vesupro.NewDefinition(randomIdent(r), randomIdent(r), calls)
+    }
+    return reflect.ValueOf(program(defs))
+}
+
+func randomIdent(r *rand.Rand) string {
+    const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ_"
+    const digits = "0123456789"
+    b := []byte{letters[r.Intn(len(letters))]}
+    for n := r.Intn(8); n > 0; n-- {
+        all := letters + digits
+        b = append(b, all[r.Intn(len(all))])
+    }
+    switch string(b) {
+    case "true", "false", "null":
+        return "x" + string(b)
+    }
+    return string(b)
+}
+
+func randomArgument(r *rand.Rand) *vesupro.ArgumentToken {
+    var tok vesupro.Token
+    var content []byte
+    switch r.Intn(6) {
+    case 0:
+        tok, content = vesupro.INT, []byte(strconv.FormatInt(r.Int63()-r.Int63(), 10))
+    case 1:
+        tok, content = vesupro.FLOAT, []byte(strconv.FormatFloat(r.NormFloat64(), 'e', -1, 64))
+    case 2:
+        s, _ := quick.Value(reflect.TypeOf(""), r)
+        tok = vesupro.STRING
+        content, _ = json.Marshal(s.Interface())
+    case 3:
+        tok, content = vesupro.TRUE, []byte("true")
+    case 4:
+        tok, content = vesupro.FALSE, []byte("false")
+    case 5:
+        m, _ := quick.Value(reflect.TypeOf(map[string][]string{}), r)
+        tok = vesupro.JSON
+        content, _ = json.Marshal(map[string]interface{}{"m": m.Interface()})
+    }
+    return &vesupro.ArgumentToken{TokenType: tok, TokenContent: content}
+}
+
+func TestPrint_RoundTrip(t *testing.T) {
+    for _, width := range []int{0, 30} {
+        p := &vesupro.Printer{LineWidth: width}
+        roundTrip := func(x program) bool {
+            out := &bytes.Buffer{}
+            if err := p.Print(out, x); err != nil {
+                t.Logf("print error: %q", err)
+                return false
+            }
+            defs, err := vesupro.ParseDefinitions(vesupro.NewTokenizer(out))
+            if err != nil {
+                t.Logf("parse error: %q", err)
+                return false
+            }
+            return reflect.DeepEqual([]*vesupro.Definition(x), defs)
+        }
+        if err := quick.Check(roundTrip, nil); err != nil {
+            t.Error(err)
+        }
+    }
+}
@@ -0,0 +1,96 @@
+// Package promvesupro implements vesupro.Metrics with Prometheus collectors.
+package promvesupro
+
+import (
+    ".."
+    "time"
+
+    "github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics collects the measurements of a vesupro.Evaluator. It implements
+// prometheus.Collector and can be registered on any registry.
+type Metrics struct {
+    programs *prometheus.CounterVec
+    definitions prometheus.Histogram
+    dispatches *prometheus.HistogramVec
+    parseFailures *prometheus.CounterVec
+    outputBytes prometheus.Histogram
+}
+
+// NewMetrics creates the collectors, using namespace as metric prefix.
+func NewMetrics(namespace string) *Metrics {
+    return &Metrics{
+        programs: prometheus.NewCounterVec(prometheus.CounterOpts{
+            Namespace: namespace,
+            Name: "programs_total",
+            Help: "Number of evaluated programs by status.",
+        }, []string{"status"}),
+        definitions: prometheus.NewHistogram(prometheus.HistogramOpts{
+            Namespace: namespace,
+            Name: "program_definitions",
+            Help: "Number of definitions per program.",
+            Buckets: prometheus.ExponentialBuckets(1, 2, 8),
+        }),
+        dispatches: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+            Namespace: namespace,
+            Name: "dispatch_duration_seconds",
+            Help: "Latency of method calls by receiver, method and status.",
+            Buckets: prometheus.DefBuckets,
+        }, []string{"receiver", "method", "status"}),
+        parseFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+            Namespace: namespace,
+            Name: "parse_failures_total",
+            Help: "Number of rejected programs by error kind.",
+        }, []string{"kind"}),
+        outputBytes: prometheus.NewHistogram(prometheus.HistogramOpts{
+            Namespace: namespace,
+            Name: "output_bytes",
+            Help: "Size of the program results in bytes.",
+            Buckets: prometheus.ExponentialBuckets(64, 4, 8),
+        }),
+    }
+}
+
+func status(err error) string {
+    if err != nil { return "error" }
+    return "ok"
+}
+
+func (m *Metrics) ObserveProgram(definitions int, outputBytes int64,
+err error) {
+    m.programs.WithLabelValues(status(err)).Inc()
+    if definitions > 0 {
+        m.definitions.Observe(float64(definitions))
+    }
+    m.outputBytes.Observe(float64(outputBytes))
+}
+
+func (m *Metrics) ObserveParseFailure(kind string) {
+    m.parseFailures.WithLabelValues(kind).Inc()
+}
+
+func (m *Metrics) ObserveDispatch(receiver string, method string,
+d time.Duration, err error) {
+    m.dispatches.WithLabelValues(receiver, method, status(err)).Observe(
+        d.Seconds())
+}
+
+func (m *Metrics) collectors() []prometheus.Collector {
+    return []prometheus.Collector{m.programs, m.definitions, m.dispatches,
+        m.parseFailures, m.outputBytes}
+}
+
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+    for _, c := range m.collectors() {
+        c.Describe(ch)
+    }
+}
+
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+    for _, c := range m.collectors() {
+        c.Collect(ch)
+    }
+}
+
+var _ vesupro.Metrics = (*Metrics)(nil)
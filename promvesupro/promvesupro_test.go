@@ -0,0 +1,62 @@
+package promvesupro_test
+
+import (
+    "./"
+    ".."
+    "testing"
+    "bytes"
+    "strings"
+
+    "github.com/prometheus/client_golang/prometheus"
+    "github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+type object struct{}
+
+func (o *object) Dispatch(mc *vesupro.MethodCall) (vesupro.VesuproObject, error) {
+    return o, nil
+}
+
+func (o *object) MarshalJSON() ([]byte, error) {
+    return []byte("{}"), nil
+}
+
+func TestMetrics(t *testing.T) {
+    metrics := promvesupro.NewMetrics("vesupro")
+    registry := prometheus.NewPedanticRegistry()
+    registry.MustRegister(metrics)
+
+    ev := vesupro.NewEvaluator(map[string]vesupro.VesuproObject{
+        "obj": &object{},
+    })
+    ev.Metrics = metrics
+
+    for _, in := range []string{
+        `v1 := obj.get(); v2 := obj.get();`,
+        `v1 := obj.get()`,
+        `v1 := none.get();`,
+    } {
+        ev.Evaluate(&bytes.Buffer{}, bytes.NewBufferString(in))
+    }
+
+    exp := `
+# HELP vesupro_parse_failures_total Number of rejected programs by error kind.
+# TYPE vesupro_parse_failures_total counter
+vesupro_parse_failures_total{kind="syntax"} 1
+# HELP vesupro_programs_total Number of evaluated programs by status.
+# TYPE vesupro_programs_total counter
+vesupro_programs_total{status="error"} 2
+vesupro_programs_total{status="ok"} 1
+`
+    err := testutil.GatherAndCompare(registry, strings.NewReader(exp),
+        "vesupro_programs_total", "vesupro_parse_failures_total")
+    if err != nil {
+        t.Error(err)
+    }
+
+    // no API is configured, so all methods are labelled as other
+    if n := testutil.CollectAndCount(metrics,
+        "vesupro_dispatch_duration_seconds"); n != 1 {
+        t.Errorf("expected 1 dispatch series, got %d.", n)
+    }
+}
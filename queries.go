@@ -0,0 +1,182 @@
+package vesupro
+
+import (
+    "bytes"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "sort"
+    "sync"
+)
+
+// QueryRequest references a prepared query instead of sending its program,
+// e.g. `{"id": "...", "vars": {"limit": 10}}`.
+type QueryRequest struct {
+    ID string `json:"id"`
+    Vars map[string]json.RawMessage `json:"vars,omitempty"`
+}
+
+// PreparedQuery is a program parsed once and evaluated many times. Its
+// arguments may be variables such as `$limit`, which are bound to the vars
+// of a request.
+type PreparedQuery struct {
+    ID string
+    Hash string // see QueryHash
+    Definitions []*Definition
+    Variables []string // names of the variables, sorted
+}
+
+// QueryHash returns the content hash of program, the hex encoded SHA-256 of
+// its text.
+func QueryHash(program []byte) string {
+    sum := sha256.Sum256(program)
+    return hex.EncodeToString(sum[:])
+}
+
+// Variables returns the sorted names of the variables used by defs.
+func Variables(defs []*Definition) []string {
+    seen := make(map[string]bool)
+    var names []string
+    for _, def := range defs {
+        for _, call := range def.MethodCalls {
+            for _, arg := range call.Arguments {
+                if arg.TokenType != VAR { continue }
+                name := string(arg.TokenContent[1:])
+                if seen[name] { continue }
+                seen[name] = true
+                names = append(names, name)
+            }
+        }
+    }
+    sort.Strings(names)
+    return names
+}
+
+// Bind returns the definitions of q with its variables replaced by vars. It
+// fails if a variable is missing or vars holds unknown ones.
+func (q *PreparedQuery) Bind(vars map[string]json.RawMessage) ([]*Definition,
+error) {
+    for name := range vars {
+        i := sort.SearchStrings(q.Variables, name)
+        if i == len(q.Variables) || q.Variables[i] != name {
+            return nil, fmt.Errorf("Unknown variable %s of query %s.", name,
+                q.ID)
+        }
+    }
+
+    defs := make([]*Definition, len(q.Definitions))
+    for i, def := range q.Definitions {
+        bound := *def
+        bound.MethodCalls = make([]*MethodCall, len(def.MethodCalls))
+        for j, call := range def.MethodCalls {
+            boundCall := &MethodCall{Name: call.Name,
+                Arguments: make([]*ArgumentToken, len(call.Arguments))}
+            for k, arg := range call.Arguments {
+                if arg.TokenType != VAR {
+                    boundCall.Arguments[k] = arg
+                    continue
+                }
+                name := string(arg.TokenContent[1:])
+                value, found := vars[name]
+                if !found {
+                    return nil, fmt.Errorf("Missing variable %s of query %s.",
+                        name, q.ID)
+                }
+                boundArg, err := ArgumentFromJSON(value)
+                if err != nil {
+                    return nil, fmt.Errorf("Variable %s of query %s: %s",
+                        name, q.ID, err)
+                }
+                boundArg.Name = arg.Name
+                boundCall.Arguments[k] = boundArg
+            }
+            bound.MethodCalls[j] = boundCall
+        }
+        defs[i] = &bound
+    }
+    return defs, nil
+}
+
+// QueryRegistry holds prepared queries by ID and content hash. It is safe
+// for concurrent use. A nil registry holds no queries.
+type QueryRegistry struct {
+    mutex sync.RWMutex
+    queries map[string]*PreparedQuery // id -> query
+    hashes map[string]*PreparedQuery  // content hash -> query
+}
+
+// NewQueryRegistry creates an empty registry.
+func NewQueryRegistry() *QueryRegistry {
+    return &QueryRegistry{queries: make(map[string]*PreparedQuery),
+        hashes: make(map[string]*PreparedQuery)}
+}
+
+// Register parses program and registers it under id, or under its content
+// hash if id is empty. Registering the same program again is a no-op,
+// registering a different one under a used id fails.
+func (r *QueryRegistry) Register(id string,
+program []byte) (*PreparedQuery, error) {
+    // the definitions refer to the program
+    program = append([]byte(nil), program...)
+    defs, err := ParseDefinitions(NewTokenizer(bytes.NewBuffer(program)))
+    if err != nil { return nil, err }
+    hash := QueryHash(program)
+    if id == "" {
+        id = hash
+    }
+
+    r.mutex.Lock()
+    defer r.mutex.Unlock()
+    if q, found := r.queries[id]; found {
+        if q.Hash != hash {
+            return nil, fmt.Errorf("Query %s is already registered.", id)
+        }
+        return q, nil
+    }
+    q := &PreparedQuery{ID: id, Hash: hash, Definitions: defs,
+        Variables: Variables(defs)}
+    r.queries[id] = q
+    if _, found := r.hashes[hash]; !found {
+        r.hashes[hash] = q
+    }
+    return q, nil
+}
+
+// Lookup returns the query registered under id.
+func (r *QueryRegistry) Lookup(id string) (*PreparedQuery, bool) {
+    if r == nil { return nil, false }
+    r.mutex.RLock()
+    defer r.mutex.RUnlock()
+    q, found := r.queries[id]
+    return q, found
+}
+
+// LookupProgram returns the query whose program is program.
+func (r *QueryRegistry) LookupProgram(program []byte) (*PreparedQuery, bool) {
+    if r == nil { return nil, false }
+    r.mutex.RLock()
+    defer r.mutex.RUnlock()
+    q, found := r.hashes[QueryHash(program)]
+    return q, found
+}
+
+// UnknownQueryError is returned for requests referencing a query which is
+// not registered.
+type UnknownQueryError struct {
+    ID string
+}
+
+func (e *UnknownQueryError) Error() string {
+    return fmt.Sprintf("Unknown query %s.", e.ID)
+}
+
+// UnregisteredProgramError is returned for programs which are not
+// registered if only registered ones are allowed.
+type UnregisteredProgramError struct {
+    Hash string
+}
+
+func (e *UnregisteredProgramError) Error() string {
+    return fmt.Sprintf("Program %s is not registered.", e.Hash)
+}
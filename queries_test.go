@@ -0,0 +1,197 @@
+package vesupro_test
+
+import (
+    "./"
+    "testing"
+    "bytes"
+    "context"
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+)
+
+const searchQuery = `r := search.run($query, limit: $limit);`
+
+func TestPreparedQuery_Bind(t *testing.T) {
+    queries := vesupro.NewQueryRegistry()
+    q, err := queries.Register("", []byte(searchQuery))
+    if err != nil { t.Fatal(err) }
+    if q.ID != vesupro.QueryHash([]byte(searchQuery)) {
+        t.Errorf("unexpected id %q.", q.ID)
+    }
+
+    tests := []struct {
+        vars string
+        out string
+        err string
+    }{
+        {vars: `{"query": "x", "limit": 5}`,
+        out: `{"r":["\"x\"","5","false","\"en\""]}`},
+        {vars: `{"query": {"any": "of"}, "limit": 1.5}`,
+        out: `{"r":["{\"any\":\"of\"}","1.5","false","\"en\""]}`},
+        {vars: `{"query": "x"}`,
+        err: "Missing variable limit of query " + q.ID + "."},
+        {vars: `{"query": "x", "limit": 5, "page": 2}`,
+        err: "Unknown variable page of query " + q.ID + "."},
+        {vars: `{"query": null, "limit": 5}`,
+        err: "Variable query of query " + q.ID +
+            ": Null is not supported."},
+    }
+
+    ev := newSearchEvaluator(t)
+    for i, tt := range tests {
+        var vars map[string]json.RawMessage
+        if err := json.Unmarshal([]byte(tt.vars), &vars); err != nil {
+            t.Fatal(err)
+        }
+        defs, err := q.Bind(vars)
+        out := &bytes.Buffer{}
+        if err == nil {
+            _, err = ev.EvaluateDefinitions(context.Background(), out,
+                defs)
+        }
+        if tt.err != "" {
+            if err == nil || err.Error() != tt.err {
+                t.Errorf("%d. error mismatch %q != %v.", i, tt.err, err)
+            }
+        } else if err != nil {
+            t.Errorf("%d. error: %q", i, err)
+        } else if tt.out != out.String() {
+            t.Errorf("%d. in/out mismatch %q != %q.", i, tt.out, out.String())
+        }
+    }
+
+    // the prepared definitions are not changed by binding
+    if vesupro.Variables(q.Definitions)[0] != "limit" {
+        t.Errorf("unexpected variables %v.", q.Variables)
+    }
+}
+
+func TestQueryRegistry_Register(t *testing.T) {
+    queries := vesupro.NewQueryRegistry()
+    first, err := queries.Register("search", []byte(searchQuery))
+    if err != nil { t.Fatal(err) }
+    again, err := queries.Register("search", []byte(searchQuery))
+    if err != nil || again != first {
+        t.Errorf("unexpected registration %v, %v.", again, err)
+    }
+    _, err = queries.Register("search", []byte(`r := search.count();`))
+    if err == nil || err.Error() != "Query search is already registered." {
+        t.Errorf("unexpected error %v.", err)
+    }
+    if _, err := queries.Register("bad", []byte(`r := $x;`)); err == nil {
+        t.Errorf("expected parse error.")
+    }
+    if q, found := queries.LookupProgram([]byte(searchQuery)); q != first ||
+    !found {
+        t.Errorf("program not found.")
+    }
+}
+
+func TestHandler_Queries(t *testing.T) {
+    h := vesupro.NewHandler(nil)
+    h.Evaluator = newSearchEvaluator(t)
+    h.Queries = vesupro.NewQueryRegistry()
+    if _, err := h.Queries.Register("search", []byte(searchQuery)); err != nil {
+        t.Fatal(err)
+    }
+    count := `r := search.count();`
+    if _, err := h.Queries.Register("", []byte(count)); err != nil {
+        t.Fatal(err)
+    }
+
+    tests := []struct {
+        body string
+        registeredOnly bool
+        status int
+        out string
+    }{
+        {body: `{"id": "search", "vars": {"query": "x", "limit": 1}}`,
+        status: http.StatusOK, out: `{"r":["\"x\"","1","false","\"en\""]}`},
+        {body: `{"id": "search", "vars": {"query": "x"}}`,
+        status: http.StatusBadRequest},
+        {body: `{"id": "other"}`, status: http.StatusNotFound},
+        {body: `r := search.run("y");`, status: http.StatusOK,
+        out: `{"r":["\"y\"","10","false","\"en\""]}`},
+        {body: searchQuery, status: http.StatusBadRequest,
+        out: "Unbound variable $limit.\n"},
+        {body: `r := search.run("y");`, registeredOnly: true,
+        status: http.StatusForbidden},
+        {body: count, registeredOnly: true, status: http.StatusOK,
+        out: `{"r":[]}`},
+        {body: `{"id": "search", "vars": {"query": "x", "limit": 1}}`,
+        registeredOnly: true, status: http.StatusOK,
+        out: `{"r":["\"x\"","1","false","\"en\""]}`},
+    }
+
+    for i, tt := range tests {
+        h.RegisteredOnly = tt.registeredOnly
+        req := httptest.NewRequest("POST", "/",
+            bytes.NewBufferString(tt.body))
+        rec := httptest.NewRecorder()
+        h.ServeHTTP(rec, req)
+
+        if tt.status != rec.Code {
+            t.Errorf("%d. status mismatch %d != %d.", i, tt.status, rec.Code)
+        } else if tt.out != "" && tt.out != rec.Body.String() {
+            t.Errorf("%d. in/out mismatch %q != %q.", i, tt.out,
+                rec.Body.String())
+        }
+    }
+}
+
+func TestHandler_RegisteredOnlyWithoutQueries(t *testing.T) {
+    h := vesupro.NewHandler(nil)
+    h.Evaluator = newSearchEvaluator(t)
+    h.RegisteredOnly = true
+
+    for i, body := range []string{`r := search.run("y");`,
+        `{"id": "search", "vars": {"query": "x", "limit": 1}}`} {
+        req := httptest.NewRequest("POST", "/", bytes.NewBufferString(body))
+        rec := httptest.NewRecorder()
+        h.ServeHTTP(rec, req)
+        if rec.Code == http.StatusOK {
+            t.Errorf("%d. unregistered program evaluated: %q.", i,
+                rec.Body.String())
+        }
+    }
+}
+
+func TestPreparedQuery_BindLimits(t *testing.T) {
+    queries := vesupro.NewQueryRegistry()
+    q, err := queries.Register("", []byte(searchQuery))
+    if err != nil { t.Fatal(err) }
+
+    tests := []struct {
+        vars string
+        limit string
+    }{
+        {vars: `{"query": "abcd", "limit": 5}`},
+        {vars: `{"query": "abcde", "limit": 5}`, limit: "MaxStringLength"},
+        {vars: `{"query": [[1]], "limit": 5}`},
+        {vars: `{"query": [[[1]]], "limit": 5}`, limit: "MaxJSONDepth"},
+    }
+
+    ev := newSearchEvaluator(t)
+    ev.Limits = &vesupro.EvalLimits{MaxStringLength: 6, MaxJSONDepth: 2}
+    for i, tt := range tests {
+        var vars map[string]json.RawMessage
+        if err := json.Unmarshal([]byte(tt.vars), &vars); err != nil {
+            t.Fatal(err)
+        }
+        defs, err := q.Bind(vars)
+        if err != nil { t.Fatal(err) }
+        _, err = ev.EvaluateDefinitions(context.Background(), &bytes.Buffer{},
+            defs)
+        limitErr, isLimitErr := err.(*vesupro.LimitError)
+        switch {
+        case tt.limit == "" && err != nil:
+            t.Errorf("%d. error: %q", i, err)
+        case tt.limit != "" && !isLimitErr:
+            t.Errorf("%d. expected LimitError, got %v", i, err)
+        case tt.limit != "" && limitErr.Limit != tt.limit:
+            t.Errorf("%d. limit mismatch %q != %q.", i, tt.limit,
+            limitErr.Limit)
+        }
+    }
+}
@@ -0,0 +1,299 @@
+// Package repl implements an interactive read-eval-print loop for vesupro
+// programs. Definitions are evaluated one at a time and their targets stay in
+// scope for later definitions.
+package repl
+
+import (
+    ".."
+    "../apidistiller"
+    "../session"
+    "bufio"
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "net/http"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// Evaluator evaluates single definitions and keeps their targets in scope.
+type Evaluator interface {
+    // EvaluateDefinition evaluates def and returns the JSON encoded result.
+    EvaluateDefinition(def *vesupro.Definition) ([]byte, error)
+    // Receivers maps the names in scope to their go type names. The type
+    // name is empty if it is unknown.
+    Receivers() map[string]string
+}
+
+// LocalEvaluator evaluates definitions with an evaluator, so that calls are
+// resolved, authorized and intercepted as they are by a server. Targets may
+// replace earlier targets, but not the receivers of the evaluator.
+type LocalEvaluator struct {
+    scope *session.Scope
+}
+
+// NewLocalEvaluator creates an evaluator on a copy of ev whose scope starts
+// with the symbol table of ev, see session.Scope.
+func NewLocalEvaluator(ev *vesupro.Evaluator) *LocalEvaluator {
+    return &LocalEvaluator{scope: session.NewScope(ev, 0)}
+}
+
+func (e *LocalEvaluator) EvaluateDefinition(
+def *vesupro.Definition) ([]byte, error) {
+    out := &bytes.Buffer{}
+    err := e.scope.Evaluate(context.Background(), out,
+        []*vesupro.Definition{def})
+    if err != nil { return nil, err }
+    return targetResult(out.Bytes(), def.TargetName)
+}
+
+func (e *LocalEvaluator) Receivers() map[string]string {
+    symTable := e.scope.SymTable()
+    rcvs := make(map[string]string, len(symTable))
+    for name, obj := range symTable {
+        rcvs[name] = vesupro.TypeName(obj)
+    }
+    return rcvs
+}
+
+// targetResult returns the result of target in the JSON object of results.
+func targetResult(results []byte, target string) ([]byte, error) {
+    var targets map[string]json.RawMessage
+    if err := json.Unmarshal(results, &targets); err != nil {
+        return nil, fmt.Errorf("Decoding results failed: %s", err)
+    }
+    out, found := targets[target]
+    if !found {
+        return nil, fmt.Errorf("Target %q missing in results.", target)
+    }
+    return out, nil
+}
+
+// RemoteEvaluator evaluates definitions at an HTTP endpoint. Since the
+// endpoint does not keep state between requests, targets are kept in scope
+// by their definitions: a definition on an earlier target is sent with the
+// calls of that target prepended, e.g. "v := users.get(1).name();" for
+// "v := u.name();" after "u := users.get(1);". Targets are thus evaluated
+// anew for every definition using them. Use a SessionEvaluator if calls
+// have side effects.
+type RemoteEvaluator struct {
+    URL string
+    HTTPClient *http.Client
+    // Types maps the receiver names offered by the endpoint to their go
+    // type names.
+    Types map[string]string
+
+    targets map[string]*vesupro.Definition
+}
+
+// NewRemoteEvaluator creates an evaluator for the endpoint at url.
+func NewRemoteEvaluator(url string, types map[string]string) *RemoteEvaluator {
+    return &RemoteEvaluator{URL: url, HTTPClient: http.DefaultClient,
+        Types: types, targets: make(map[string]*vesupro.Definition)}
+}
+
+func (e *RemoteEvaluator) EvaluateDefinition(
+def *vesupro.Definition) ([]byte, error) {
+    if _, found := e.Types[def.TargetName]; found {
+        return nil, fmt.Errorf("Target %s shadows a receiver.", def.TargetName)
+    }
+    if target, found := e.targets[def.ReceiverName]; found {
+        n := len(target.MethodCalls)
+        def = vesupro.NewDefinition(def.TargetName, target.ReceiverName,
+            append(target.MethodCalls[:n:n], def.MethodCalls...))
+    }
+
+    body := &bytes.Buffer{}
+    err := vesupro.Print(body, []*vesupro.Definition{def})
+    if err != nil { return nil, err }
+
+    resp, err := e.HTTPClient.Post(e.URL, "text/plain; charset=utf-8", body)
+    if err != nil { return nil, err }
+    defer resp.Body.Close()
+
+    if resp.StatusCode != http.StatusOK {
+        msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+        return nil, fmt.Errorf("Request failed with status %s: %s",
+            resp.Status, bytes.TrimSpace(msg))
+    }
+
+    results, err := ioutil.ReadAll(resp.Body)
+    if err != nil { return nil, err }
+    out, err := targetResult(results, def.TargetName)
+    if err != nil { return nil, err }
+    if e.targets == nil {
+        e.targets = make(map[string]*vesupro.Definition)
+    }
+    e.targets[def.TargetName] = def
+    return out, nil
+}
+
+func (e *RemoteEvaluator) Receivers() map[string]string {
+    rcvs := make(map[string]string, len(e.Types)+len(e.targets))
+    for target := range e.targets {
+        rcvs[target] = ""
+    }
+    for name, typeName := range e.Types {
+        rcvs[name] = typeName
+    }
+    return rcvs
+}
+
+// SessionEvaluator evaluates definitions in a session of a session server,
+// e.g. over a net.Conn. The server keeps the targets of successful
+// definitions in scope.
+type SessionEvaluator struct {
+    // Types maps the receiver names offered by the server to their go type
+    // names.
+    Types map[string]string
+
+    encoder *json.Encoder
+    decoder *json.Decoder
+    requests int
+    targets map[string]bool
+}
+
+// NewSessionEvaluator creates an evaluator for the session served on conn.
+func NewSessionEvaluator(conn io.ReadWriter,
+types map[string]string) *SessionEvaluator {
+    return &SessionEvaluator{Types: types, encoder: json.NewEncoder(conn),
+        decoder: json.NewDecoder(conn), targets: make(map[string]bool)}
+}
+
+func (e *SessionEvaluator) EvaluateDefinition(
+def *vesupro.Definition) ([]byte, error) {
+    program := &bytes.Buffer{}
+    err := vesupro.Print(program, []*vesupro.Definition{def})
+    if err != nil { return nil, err }
+
+    e.requests++
+    req := &session.Request{ID: strconv.Itoa(e.requests),
+        Program: program.String()}
+    if err := e.encoder.Encode(req); err != nil { return nil, err }
+    resp := &session.Response{}
+    if err := e.decoder.Decode(resp); err != nil { return nil, err }
+    if resp.ID != req.ID {
+        return nil, fmt.Errorf("Expected response %s, got %s.", req.ID,
+            resp.ID)
+    }
+    if resp.Error != "" { return nil, errors.New(resp.Error) }
+
+    out, err := targetResult(resp.Result, def.TargetName)
+    if err != nil { return nil, err }
+    e.targets[def.TargetName] = true
+    return out, nil
+}
+
+func (e *SessionEvaluator) Receivers() map[string]string {
+    rcvs := make(map[string]string, len(e.Types)+len(e.targets))
+    for target := range e.targets {
+        rcvs[target] = ""
+    }
+    for name, typeName := range e.Types {
+        rcvs[name] = typeName
+    }
+    return rcvs
+}
+
+// REPL reads definitions, evaluates them and pretty-prints the results.
+type REPL struct {
+    Evaluator Evaluator
+    // API is used to complete method names. It may be nil.
+    API *apidistiller.API
+    Prompt string
+    ContinuationPrompt string
+}
+
+// New creates a REPL.
+func New(ev Evaluator, api *apidistiller.API) *REPL {
+    return &REPL{Evaluator: ev, API: api, Prompt: "> ",
+        ContinuationPrompt: "... "}
+}
+
+// Eval evaluates all definitions in src and writes the indented results to
+// w.
+func (r *REPL) Eval(w io.Writer, src string) error {
+    defs, err := vesupro.ParseDefinitions(
+        vesupro.NewTokenizer(bytes.NewBufferString(src)))
+    if err != nil { return err }
+
+    for _, def := range defs {
+        out, err := r.Evaluator.EvaluateDefinition(def)
+        if err != nil {
+            return fmt.Errorf("%s: %s", def.TargetName, err)
+        }
+        pretty := &bytes.Buffer{}
+        if json.Indent(pretty, out, "", "  ") != nil {
+            pretty.Reset()
+            pretty.Write(out)
+        }
+        fmt.Fprintf(w, "%s = %s\n", def.TargetName, pretty.Bytes())
+    }
+    return nil
+}
+
+var (
+    methodPrefix = regexp.MustCompile(`(?:^|[^.\w)])(\w+)\.(\w*)$`)
+    receiverPrefix = regexp.MustCompile(`:=\s*(\w*)$`)
+)
+
+// Complete returns the completions for the end of line, e.g. for the
+// completer of a line editor. Receiver names are completed after the
+// definition operator and method names after a receiver whose type is known
+// to the API.
+func (r *REPL) Complete(line string) []string {
+    rcvs := r.Evaluator.Receivers()
+    completions := make([]string, 0)
+
+    if m := methodPrefix.FindStringSubmatch(line); m != nil {
+        typeName, found := rcvs[m[1]]
+        if !found || r.API == nil { return completions }
+        for _, method := range r.API.Methods[typeName] {
+            if strings.HasPrefix(method.WireName(), m[2]) {
+                completions = append(completions,
+                    m[1]+"."+method.WireName()+"(")
+            }
+        }
+    } else if m := receiverPrefix.FindStringSubmatch(line); m != nil {
+        for name := range rcvs {
+            if strings.HasPrefix(name, m[1]) {
+                completions = append(completions, name)
+            }
+        }
+    }
+    sort.Strings(completions)
+    return completions
+}
+
+// Run reads input from in until EOF and writes results and errors to out.
+// Input is collected until a line ends with a semicolon. Lines are read as
+// they are, Run does not edit or complete them; a line editor may offer
+// Complete to its user instead.
+func (r *REPL) Run(in io.Reader, out io.Writer) error {
+    scanner := bufio.NewScanner(in)
+    pending := ""
+
+    io.WriteString(out, r.Prompt)
+    for scanner.Scan() {
+        pending += scanner.Text() + "\n"
+        if strings.HasSuffix(strings.TrimSpace(pending), ";") {
+            if err := r.Eval(out, pending); err != nil {
+                fmt.Fprintf(out, "error: %s\n", err)
+            }
+            pending = ""
+        }
+
+        if pending == "" {
+            io.WriteString(out, r.Prompt)
+        } else {
+            io.WriteString(out, r.ContinuationPrompt)
+        }
+    }
+    return scanner.Err()
+}
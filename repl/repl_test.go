@@ -0,0 +1,167 @@
+package repl_test
+
+import (
+    "./"
+    ".."
+    "../apidistiller"
+    "../session"
+    "testing"
+    "bytes"
+    "context"
+    "encoding/json"
+    "io/ioutil"
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "reflect"
+    "strings"
+)
+
+type Users struct{}
+
+type User struct {
+    ID string
+}
+
+func (u *Users) Dispatch(mc *vesupro.MethodCall) (vesupro.VesuproObject, error) {
+    id, err := mc.Arguments[0].ToInt64()
+    if err != nil { return nil, err }
+    return &User{ID: strings.Repeat("x", int(id))}, nil
+}
+
+func (u *Users) MarshalJSON() ([]byte, error) {
+    return []byte(`"users"`), nil
+}
+
+func (u *User) Dispatch(mc *vesupro.MethodCall) (vesupro.VesuproObject, error) {
+    return &User{ID: u.ID + mc.Name}, nil
+}
+
+func (u *User) MarshalJSON() ([]byte, error) {
+    return json.Marshal(struct{ ID string }{u.ID})
+}
+
+func newREPL() *repl.REPL {
+    api := apidistiller.NewAPI("repl_test")
+    api.Methods["Users"] = []*apidistiller.Method{
+        &apidistiller.Method{Name: "Get"},
+        &apidistiller.Method{Name: "Find"},
+        &apidistiller.Method{Name: "Flush"},
+    }
+    ev := repl.NewLocalEvaluator(vesupro.NewEvaluator(
+        map[string]vesupro.VesuproObject{"users": &Users{}}))
+    return repl.New(ev, api)
+}
+
+func TestREPL_Run(t *testing.T) {
+    in := strings.NewReader("u := users.get(2);\nv := u\n  .suffix();\n" +
+        "w := nobody.get(1);\nusers := u.x();\n")
+    out := &bytes.Buffer{}
+
+    if err := newREPL().Run(in, out); err != nil {
+        t.Fatalf("error: %q", err)
+    }
+
+    exp := "> u = {\n  \"ID\": \"xx\"\n}\n" +
+        "> ... v = {\n  \"ID\": \"xxsuffix\"\n}\n" +
+        "> error: w: Receiver not found nobody.\n" +
+        "> error: users: Target users shadows a receiver.\n> "
+    if exp != out.String() {
+        t.Errorf("in/out mismatch %q != %q.", exp, out.String())
+    }
+}
+
+func TestREPL_Complete(t *testing.T) {
+    r := newREPL()
+    r.Eval(&bytes.Buffer{}, "u := users.get(1);")
+
+    tests := []struct {
+        in string
+        out []string
+    }{
+        {in: "v := ", out: []string{"u", "users"}},
+        {in: "v := us", out: []string{"users"}},
+        {in: "v := users.", out: []string{
+            "users.find(", "users.flush(", "users.get("}},
+        {in: "v := users.f", out: []string{"users.find(", "users.flush("}},
+        {in: "v := users.get(1).f", out: []string{}},
+        {in: "v := u.", out: []string{}},
+    }
+
+    for i, tt := range tests {
+        out := r.Complete(tt.in)
+        if !reflect.DeepEqual(tt.out, out) {
+            t.Errorf("%d. in/out mismatch %q != %q.", i, tt.out, out)
+        }
+    }
+}
+
+func TestRemoteEvaluator(t *testing.T) {
+    var programs []string
+    server := httptest.NewServer(http.HandlerFunc(func(
+    w http.ResponseWriter, r *http.Request) {
+        program, _ := ioutil.ReadAll(r.Body)
+        programs = append(programs, string(program))
+        vesupro.Evaluate(w, bytes.NewBuffer(program),
+            map[string]vesupro.VesuproObject{"users": &Users{}})
+    }))
+    defer server.Close()
+
+    ev := repl.NewRemoteEvaluator(server.URL,
+        map[string]string{"users": "Users"})
+    out := &bytes.Buffer{}
+    err := repl.New(ev, nil).Eval(out,
+        "u := users.get(1); v := u.suffix(); w := v.more();")
+    if err != nil { t.Fatalf("error: %q", err) }
+    err = repl.New(ev, nil).Eval(out, "users := u.x();")
+    if err == nil { t.Errorf("expected shadowing error") }
+
+    // every definition is sent on its own, starting at a receiver
+    exp := []string{"u := users.get(1);\n", "v := users.get(1).suffix();\n",
+        "w := users.get(1).suffix().more();\n"}
+    if !reflect.DeepEqual(exp, programs) {
+        t.Errorf("programs mismatch %q != %q.", exp, programs)
+    }
+    results := "u = {\n  \"ID\": \"x\"\n}\n" +
+        "v = {\n  \"ID\": \"xsuffix\"\n}\n" +
+        "w = {\n  \"ID\": \"xsuffixmore\"\n}\n"
+    if results != out.String() {
+        t.Errorf("results mismatch %q != %q.", results, out.String())
+    }
+    rcvs := map[string]string{"users": "Users", "u": "", "v": "", "w": ""}
+    if !reflect.DeepEqual(rcvs, ev.Receivers()) {
+        t.Errorf("receivers mismatch %q != %q.", rcvs, ev.Receivers())
+    }
+}
+
+func TestSessionEvaluator(t *testing.T) {
+    server := session.NewServer(map[string]vesupro.VesuproObject{
+        "users": &Users{},
+    })
+    client, conn := net.Pipe()
+    defer client.Close()
+    go func() {
+        server.Serve(context.Background(), conn)
+        conn.Close()
+    }()
+
+    ev := repl.NewSessionEvaluator(client,
+        map[string]string{"users": "Users"})
+    in := strings.NewReader("u := users.get(2);\nv := u.suffix();\n" +
+        "w := x.get(1);\n")
+    out := &bytes.Buffer{}
+    if err := repl.New(ev, nil).Run(in, out); err != nil {
+        t.Fatalf("error: %q", err)
+    }
+
+    exp := "> u = {\n  \"ID\": \"xx\"\n}\n" +
+        "> v = {\n  \"ID\": \"xxsuffix\"\n}\n" +
+        "> error: w: Receiver not found x.\n> "
+    if exp != out.String() {
+        t.Errorf("in/out mismatch %q != %q.", exp, out.String())
+    }
+    rcvs := map[string]string{"users": "Users", "u": "", "v": ""}
+    if !reflect.DeepEqual(rcvs, ev.Receivers()) {
+        t.Errorf("receivers mismatch %q != %q.", rcvs, ev.Receivers())
+    }
+}
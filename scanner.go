@@ -32,6 +32,7 @@ type RuneStream struct {
 func (s* RuneStream) Read() rune {
     ch, lastSize, err := s.reader.ReadRune()
 	if err != nil {
+        s.lastSize = 0
 		return eof
 	}
     s.lastSize = lastSize
@@ -43,10 +44,11 @@ func (s* RuneStream) Read() rune {
 func (s* RuneStream) Unread() {
     if s.lastSize > 0 {
         err := s.reader.UnreadRune()
-        if err != nil {
+        if err == nil {
             s.buf.Truncate(s.buf.Len() - s.lastSize)
             s.runeOffset--
         }
+        s.lastSize = 0
     }
 }
 
@@ -72,6 +74,11 @@ type BufferedRuneStream struct {
 }
 
 func (s* BufferedRuneStream) Read() rune {
+    if s.off >= len(s.data) {
+        s.lastSize = 0
+        s.runeOffset++
+        return eof
+    }
     ch, lastSize := utf8.DecodeRune(s.data[s.off:])
     s.lastSize = lastSize
     s.off = s.off + lastSize
@@ -105,6 +112,14 @@ func NewTokenizer(r io.Reader) Tokenizer {
 }
 
 func Scan(t Tokenizer, ignoreWS bool) (tok Token) {
+    tok, _ = scan(t, ignoreWS, nil)
+    return
+}
+
+// scan is Scan, which fails with a *LimitError as soon as a string or JSON
+// token exceeds limits.
+func scan(t Tokenizer, ignoreWS bool,
+limits *EvalLimits) (tok Token, err error) {
     t.StartToken()
     ch := t.Read()
     tok = ILLEGAL
@@ -113,7 +128,7 @@ func Scan(t Tokenizer, ignoreWS bool) (tok Token) {
         // consume whitespace
         tok = scanWhitespace(t)
         if ignoreWS {
-            return Scan(t, false)
+            return scan(t, false, limits)
         }
     } else if isIdentStart(ch) {
         // consume ident
@@ -122,20 +137,24 @@ func Scan(t Tokenizer, ignoreWS bool) (tok Token) {
         tok = scanNumber(t, ch)
     } else {
         switch(ch) {
-        case '"': tok = scanString(t)
+        case '"': tok, err = limits.scanString(t)
         case '.': tok = DOT
         case ',': tok = COMMA
         case '-': tok = scanNumber(t, ch)
         case ';': tok = SEMI
-        case '{': tok = FastScanJSON(t)
-        //case '[': tok = OPEN_BRACKET // arrays are not supported yet
-        //case ']': tok = CLOSE_BRACKET // arrays are not supported yet
+        case '{', '[': tok, err = limits.scanJSON(t)
+        case '$': tok = scanVariable(t)
         case '(': tok = OPEN_PAREN
         case ')': tok = CLOSE_PAREN
         case ':':
             ch = t.Read()
             if ch == '=' {
                 tok = DEF_OP
+            } else {
+                if ch != eof {
+                    t.Unread()
+                }
+                tok = COLON
             }
         case eof: tok = EOF
         }
@@ -144,7 +163,14 @@ func Scan(t Tokenizer, ignoreWS bool) (tok Token) {
 }
 
 func ScanExpTok(t Tokenizer, want Token, ignoreWS bool) error {
-    got := Scan(t, ignoreWS)
+    return scanExpTok(t, want, ignoreWS, nil)
+}
+
+// scanExpTok is ScanExpTok, which scans with limits, see scan.
+func scanExpTok(t Tokenizer, want Token, ignoreWS bool,
+limits *EvalLimits) error {
+    got, err := scan(t, ignoreWS, limits)
+    if err != nil { return err }
     if got != want {
         return fmt.Errorf(
             "Expected token id %d, got %d. (Rune pos.: %d)", want, got,
@@ -283,7 +309,21 @@ func scanNumber(t Tokenizer, ch rune) (tok Token) {
     return
 }
 
-func scanString(t Tokenizer) Token {
+// scanVariable scans the name of a variable such as $limit.
+func scanVariable(t Tokenizer) Token {
+    if !isIdentStart(t.Read()) { return ILLEGAL }
+    ch := t.Read()
+    for isIdentLetter(ch) {
+        ch = t.Read()
+    }
+    t.Unread()
+    return VAR
+}
+
+// scanString scans a string. It stops as soon as the string is longer than
+// maxLength bytes including quotes, unless maxLength is 0, and reports
+// whether it did.
+func scanString(t Tokenizer, maxLength int) (tok Token, exceeded bool) {
     const (
         InString = iota
         Esc
@@ -296,8 +336,13 @@ func scanString(t Tokenizer) Token {
     )
 
     state := InString
+    length := 1 // the opening quote has been read by the caller
 
     for ch := t.Read(); ch != eof; ch = t.Read() {
+        length += utf8.RuneLen(ch)
+        if maxLength > 0 && length > maxLength {
+            return ILLEGAL, true
+        }
         switch(state) {
         case InString:
             switch {
@@ -335,35 +380,65 @@ func scanString(t Tokenizer) Token {
     }
 
     if state != End {
-        return ILLEGAL
+        return ILLEGAL, false
     }
-    return STRING
+    return STRING, false
 }
 
-// FastScanJSON scans a json object as one token
+// FastScanJSON scans a json object or array as one token
 // this is useful when using an third-party json parser which expects
 // a byte-slice as input (such as json, ffjson, etc.)
 func FastScanJSON(t Tokenizer) (tok Token) {
-    nestingLevel := 0
-    // the closing brace may only appear within a string
+    tok, _ = scanJSON(t, nil)
+    return
+}
+
+// scanJSON is FastScanJSON. It stops as soon as the JSON value exceeds
+// MaxJSONDepth or MaxJSONLength, or a string within it MaxStringLength, and
+// returns the name of the exceeded limit. limits may be nil.
+func scanJSON(t Tokenizer, limits *EvalLimits) (tok Token, exceeded string) {
+    var l EvalLimits
+    if limits != nil {
+        l = *limits
+    }
+    // the opening brace or bracket has been read by Scan
+    closers := []rune{closer(rune(t.CurrentToken()[0]))}
     for ch := t.Read(); ; ch = t.Read() {
+        if l.MaxJSONLength > 0 && len(t.CurrentToken()) > l.MaxJSONLength {
+            return ILLEGAL, "MaxJSONLength"
+        }
         switch ch {
         case '"':
-            scanString(t)
-        case '{':
-            nestingLevel += 1
-        case '}':
-            if nestingLevel == 0 {
-                return JSON
+            // the string counts towards both limits
+            maxLength, limit := l.MaxStringLength, "MaxStringLength"
+            rest := l.MaxJSONLength - len(t.CurrentToken()) + 1
+            if l.MaxJSONLength > 0 && (maxLength <= 0 || rest < maxLength) {
+                maxLength, limit = rest, "MaxJSONLength"
+            }
+            strTok, strExceeded := scanString(t, maxLength)
+            if strExceeded { return ILLEGAL, limit }
+            if strTok != STRING { return ILLEGAL, "" }
+        case '{', '[':
+            closers = append(closers, closer(ch))
+            if l.MaxJSONDepth > 0 && len(closers) > l.MaxJSONDepth {
+                return ILLEGAL, "MaxJSONDepth"
             }
-            nestingLevel -= 1
+        case '}', ']':
+            if ch != closers[len(closers)-1] { return ILLEGAL, "" }
+            closers = closers[:len(closers)-1]
+            if len(closers) == 0 { return JSON, "" }
         case eof:
-            return ILLEGAL
+            return ILLEGAL, ""
         }
-
     }
 }
 
+// closer returns the closing bracket of the opening bracket ch.
+func closer(ch rune) rune {
+    if ch == '{' { return '}' }
+    return ']'
+}
+
 
 func isIdentStart( ch rune ) bool {
     return  'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' ||
@@ -379,4 +454,6 @@ func isWhitespace( r rune ) bool { return unicode.IsSpace(r) }
 func isDigit( r rune ) bool { return unicode.IsDigit(r) }
 func isLetter( r rune ) bool { return unicode.IsLetter(r) }
 
-var eof = utf8.RuneError
+// eof is returned by Tokenizer.Read at the end of the input. It must not be
+// utf8.RuneError, which may legitimately appear within strings.
+var eof = rune(-1)
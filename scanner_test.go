@@ -4,6 +4,7 @@ import (
     "./"
     "testing"
     "bytes"
+    "strings"
 )
 
 func TestScanner_Scan(t *testing.T) {
@@ -50,21 +51,38 @@ func TestScanner_Scan(t *testing.T) {
         {s: `"some\ua020String"`, tok: vesupro.STRING, lit: `"some\ua020String"`},
         {s: `"some\\String"`, tok: vesupro.STRING, lit: `"some\\String"`},
         {s: `"some\uString"`, tok: vesupro.ILLEGAL, lit: `"some\uS`},
+        {s: "\"\uFFFD\"", tok: vesupro.STRING, lit: "\"\uFFFD\""},
 
 
+        {s: `{"a": [1, "]"]}`, tok: vesupro.JSON, lit: `{"a": [1, "]"]}`},
+        {s: `[{"a": 1}, 2] 3`, tok: vesupro.JSON, lit: `[{"a": 1}, 2]`},
+        {s: `{]`, tok: vesupro.ILLEGAL, lit: `{]`},
+        {s: `{"a": [1}]`, tok: vesupro.ILLEGAL, lit: `{"a": [1}`},
+        {s: `["a\x"]`, tok: vesupro.ILLEGAL, lit: `["a\x`},
+
+        {s: "$limit)", tok: vesupro.VAR, lit: "$limit"},
+        {s: "$1", tok: vesupro.ILLEGAL, lit: "$1"},
+        {s: `[1, 2`, tok: vesupro.ILLEGAL, lit: `[1, 2`},
+
         {s: `  "ignoreWS"`, tok: vesupro.STRING, lit: `"ignoreWS"`, ignoreWS: true},
     }
 
     for i, tt := range tests {
-		s := vesupro.NewTokenizer(bytes.NewBufferString(tt.s))
-		tok := vesupro.Scan(s, tt.ignoreWS)
-        lit := string(s.CurrentToken())
-		if tt.tok != tok {
-			t.Errorf("%d. %q token mismatch: exp=%d got=%d <%q>", i, tt.s,
-            tt.tok, tok, lit)
-		} else if tt.lit != lit {
-			t.Errorf("%d. %q literal mismatch: exp=%q got=%q", i, tt.s,
-            tt.lit, lit)
-		}
+        // bytes.Buffer yields a BufferedRuneStream, any other reader a
+        // RuneStream; both have to produce the same tokens.
+        for _, s := range []vesupro.Tokenizer{
+            vesupro.NewTokenizer(bytes.NewBufferString(tt.s)),
+            vesupro.NewTokenizer(strings.NewReader(tt.s)),
+        } {
+            tok := vesupro.Scan(s, tt.ignoreWS)
+            lit := string(s.CurrentToken())
+            if tt.tok != tok {
+                t.Errorf("%d. %q token mismatch: exp=%d got=%d <%q>", i, tt.s,
+                tt.tok, tok, lit)
+            } else if tt.lit != lit {
+                t.Errorf("%d. %q literal mismatch: exp=%q got=%q", i, tt.s,
+                tt.lit, lit)
+            }
+        }
 	}
 }
@@ -0,0 +1,123 @@
+package vesupro
+
+import (
+    "encoding/json"
+    "fmt"
+    "sort"
+)
+
+// SchemaReceiver is the name of the built-in introspection receiver. Unless
+// disabled, programs may call
+//
+//     r := __schema.receivers();
+//     m := __schema.methods("users");
+//
+// to list the receivers of the symbol table and the methods of a receiver as
+// described by the evaluator's API. A receiver of the same name in the
+// symbol table takes precedence.
+const SchemaReceiver = "__schema"
+
+// ReceiverSchema describes a receiver of the symbol table.
+type ReceiverSchema struct {
+    Name string `json:"name"`
+    Type string `json:"type"` // go type name, empty if unknown
+    Doc string `json:"doc,omitempty"`
+}
+
+// MethodSchema describes a method of a receiver.
+type MethodSchema struct {
+    Name string `json:"name"` // wire name
+    Params []*ParamSchema `json:"params"`
+    Cost uint `json:"cost,omitempty"`
+    Cacheable bool `json:"cacheable,omitempty"`
+    Doc string `json:"doc,omitempty"`
+}
+
+// ParamSchema describes a parameter of a method.
+type ParamSchema struct {
+    Name string `json:"name,omitempty"`
+    Type string `json:"type"` // e.g. "int", "*User" or "...int"
+    Default string `json:"default,omitempty"` // literal, empty if required
+}
+
+// schema answers introspection calls of a program.
+type schema struct {
+    evaluator *Evaluator
+}
+
+// receiver returns the receiver called name, including the introspection
+// receiver.
+func (e *Evaluator) receiver(name string) (VesuproObject, bool) {
+    rcvObj, found := e.SymTable[name]
+    if !found && name == SchemaReceiver && !e.DisableSchema {
+        return &schema{evaluator: e}, true
+    }
+    return rcvObj, found
+}
+
+func (s *schema) Dispatch(c *MethodCall) (VesuproObject, error) {
+    var result interface{}
+    switch {
+    case c.Name == "receivers" && len(c.Arguments) == 0:
+        result = s.receivers()
+    case c.Name == "methods" && len(c.Arguments) == 1:
+        name, err := c.Arguments[0].ToString()
+        if err != nil { return nil, err }
+        if err := json.Unmarshal([]byte(name), &name); err != nil {
+            return nil, err
+        }
+        methods, err := s.methods(name)
+        if err != nil { return nil, err }
+        result = methods
+    default:
+        return nil, fmt.Errorf("Unknown method %s of %s.", c.Name,
+            SchemaReceiver)
+    }
+
+    out, err := json.Marshal(result)
+    if err != nil { return nil, err }
+    return RawJSON(out), nil
+}
+
+func (s *schema) MarshalJSON() ([]byte, error) {
+    return []byte(`"` + SchemaReceiver + `"`), nil
+}
+
+func (s *schema) receivers() []*ReceiverSchema {
+    rcvs := make([]*ReceiverSchema, 0, len(s.evaluator.SymTable))
+    for name, obj := range s.evaluator.SymTable {
+        rcv := &ReceiverSchema{Name: name,
+            Type: APITypeName(s.evaluator.API, obj)}
+        if s.evaluator.API != nil {
+            rcv.Doc = s.evaluator.API.TypeDocs[rcv.Type]
+        }
+        rcvs = append(rcvs, rcv)
+    }
+    sort.Slice(rcvs, func(i, j int) bool {
+        return rcvs[i].Name < rcvs[j].Name
+    })
+    return rcvs
+}
+
+func (s *schema) methods(receiver string) ([]*MethodSchema, error) {
+    obj, found := s.evaluator.SymTable[receiver]
+    if !found {
+        return nil, fmt.Errorf("Receiver not found %s.", receiver)
+    }
+    methods := make([]*MethodSchema, 0)
+    if s.evaluator.API == nil { return methods, nil }
+
+    api := s.evaluator.API
+    for _, method := range api.Methods[APITypeName(api, obj)] {
+        m := &MethodSchema{Name: method.WireName(),
+            Params: make([]*ParamSchema, len(method.Params)),
+            Cost: method.Cost, Cacheable: method.Cacheable,
+            Doc: method.Doc}
+        for i, param := range method.Params {
+            m.Params[i] = &ParamSchema{Name: param.Name,
+                Type: param.GoType(), Default: param.Default}
+        }
+        methods = append(methods, m)
+    }
+    return methods, nil
+}
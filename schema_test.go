@@ -0,0 +1,103 @@
+package vesupro_test
+
+import (
+    "./"
+    "./apidistiller"
+    "testing"
+    "bytes"
+    "go/parser"
+    "go/token"
+)
+
+const schemaSource = `package users
+
+// Users is the user directory.
+type Users struct{}
+
+type (
+    // Settings are per user.
+    Settings struct{}
+)
+
+// Search finds users by name.
+// vesupro: export cost=10
+func (u *Users) Search(query string, limit int) {}
+
+// vesupro: export cacheable
+func (u *Users) Get(id int) {}
+
+// vesupro: export
+// Update replaces all settings.
+func (s *Settings) Update(*Settings) {}
+`
+
+func newSchemaEvaluator(t *testing.T) *vesupro.Evaluator {
+    f, err := parser.ParseFile(token.NewFileSet(), "users.go", schemaSource,
+        parser.ParseComments)
+    if err != nil { t.Fatal(err) }
+    api := apidistiller.NewAPI("users")
+    if err := api.DistillFromAstFile(f); err != nil { t.Fatal(err) }
+
+    ev := vesupro.NewEvaluator(map[string]vesupro.VesuproObject{
+        "users": &Users{}, "settings": &Settings{},
+    })
+    ev.API = api
+    return ev
+}
+
+func TestEvaluator_Schema(t *testing.T) {
+    tests := []struct {
+        in string
+        out string
+    }{
+        {in: `r := __schema.receivers();`,
+        out: `{"r":[{"name":"settings","type":"Settings",` +
+            `"doc":"Settings are per user.\n"},` +
+            `{"name":"users","type":"Users",` +
+            `"doc":"Users is the user directory.\n"}]}`},
+        {in: `m := __schema.methods("users");`,
+        out: `{"m":[{"name":"search","params":[` +
+            `{"name":"query","type":"string"},{"name":"limit","type":"int"}],` +
+            `"cost":10,"doc":"Search finds users by name.\n"},` +
+            `{"name":"get","params":[{"name":"id","type":"int"}],` +
+            `"cacheable":true}]}`},
+        {in: `m := __schema.methods("settings");`,
+        out: `{"m":[{"name":"update","params":[{"type":"*Settings"}],` +
+            `"doc":"Update replaces all settings.\n"}]}`},
+    }
+
+    ev := newSchemaEvaluator(t)
+    for i, tt := range tests {
+        out := &bytes.Buffer{}
+        err := ev.Evaluate(out, bytes.NewBufferString(tt.in))
+        if err != nil {
+            t.Errorf("%d. error: %q", i, err)
+        } else if tt.out != out.String() {
+            t.Errorf("%d. in/out mismatch %q != %q.", i, tt.out, out.String())
+        }
+    }
+}
+
+func TestEvaluator_SchemaErrors(t *testing.T) {
+    tests := []struct {
+        in string
+        err string
+        disabled bool
+    }{
+        {in: `m := __schema.methods("nobody");`,
+        err: "__schema.methods: Receiver not found nobody."},
+        {in: `m := __schema.methods();`,
+        err: "__schema.methods: Unknown method methods of __schema."},
+        {in: `r := __schema.receivers();`, disabled: true,
+        err: "Receiver not found __schema."},
+    }
+
+    for i, tt := range tests {
+        ev := newSchemaEvaluator(t)
+        ev.DisableSchema = tt.disabled
+        err := ev.Evaluate(&bytes.Buffer{}, bytes.NewBufferString(tt.in))
+        if err == nil || err.Error() != tt.err {
+            t.Errorf("%d. error mismatch %q != %v.", i, tt.err, err)
+        }
+    }
+}
@@ -0,0 +1,116 @@
+package session
+
+import (
+    ".."
+    "context"
+    "fmt"
+    "io"
+)
+
+// DefaultMaxTargets is the number of targets a scope keeps unless another
+// limit is given.
+const DefaultMaxTargets = 1024
+
+// Scope evaluates the programs of a single client, e.g. those of a session
+// or the definitions of a REPL, whose targets stay in scope for its later
+// programs. Targets may replace earlier targets, but not receivers. Calls
+// on targets are authorized and observed as calls on the receiver the target
+// derives from, see vesupro.Evaluator.Origins. A Scope is not safe for
+// concurrent use.
+type Scope struct {
+    evaluator vesupro.Evaluator
+    receivers map[string]vesupro.VesuproObject
+    maxTargets int
+}
+
+// NewScope creates a scope on a copy of ev whose scope starts with the
+// symbol table of ev. It keeps at most maxTargets targets, DefaultMaxTargets
+// if maxTargets is 0. The result cache of ev is not used, as the scope adds
+// receivers of its own.
+func NewScope(ev *vesupro.Evaluator, maxTargets int) *Scope {
+    if maxTargets == 0 {
+        maxTargets = DefaultMaxTargets
+    }
+    s := &Scope{evaluator: *ev, receivers: ev.SymTable,
+        maxTargets: maxTargets}
+    s.evaluator.SymTable = make(map[string]vesupro.VesuproObject,
+        len(ev.SymTable))
+    for name, obj := range ev.SymTable {
+        s.evaluator.SymTable[name] = obj
+    }
+    s.evaluator.Origins = make(map[string]string)
+    s.evaluator.Cache = nil
+    return s
+}
+
+// SymTable returns the receivers and targets in scope. It must not be
+// modified.
+func (s *Scope) SymTable() map[string]vesupro.VesuproObject {
+    return s.evaluator.SymTable
+}
+
+// Evaluate evaluates defs and writes their results as JSON object to output.
+// The targets are added to the scope only if all definitions succeed.
+func (s *Scope) Evaluate(ctx context.Context, output io.Writer,
+defs []*vesupro.Definition) error {
+    added := 0
+    for _, def := range defs {
+        if _, found := s.receivers[def.TargetName]; found {
+            return fmt.Errorf("Target %s shadows a receiver.", def.TargetName)
+        }
+        if _, found := s.evaluator.SymTable[def.TargetName]; !found {
+            added++
+        }
+    }
+    if len(s.evaluator.Origins)+added > s.maxTargets {
+        return fmt.Errorf("Scope is limited to %d targets.", s.maxTargets)
+    }
+
+    enc := &scopeEncoder{targets: make(map[string]vesupro.VesuproObject)}
+    evaluator := s.evaluator
+    evaluator.Encoder = enc
+    _, err := evaluator.EvaluateDefinitions(ctx, output, defs)
+    if err != nil { return err }
+
+    // origins refer to receivers, which cannot be replaced
+    origins := make(map[string]string, len(defs))
+    for _, def := range defs {
+        origins[def.TargetName] = s.origin(def.ReceiverName)
+    }
+    for target, obj := range enc.targets {
+        s.evaluator.SymTable[target] = obj
+        s.evaluator.Origins[target] = origins[target]
+    }
+    return nil
+}
+
+// origin returns the receiver name derives from.
+func (s *Scope) origin(name string) string {
+    if origin, found := s.evaluator.Origins[name]; found { return origin }
+    return name
+}
+
+// scopeEncoder encodes results as JSON and collects the objects of the
+// targets.
+type scopeEncoder struct {
+    vesupro.JSONEncoder
+    targets map[string]vesupro.VesuproObject
+}
+
+func (e *scopeEncoder) NewResultWriter(w io.Writer,
+n int) (vesupro.ResultWriter, error) {
+    results, err := e.JSONEncoder.NewResultWriter(w, n)
+    if err != nil { return nil, err }
+    return &scopeWriter{ResultWriter: results, encoder: e}, nil
+}
+
+type scopeWriter struct {
+    vesupro.ResultWriter
+    encoder *scopeEncoder
+}
+
+func (w *scopeWriter) WriteResult(target string,
+obj vesupro.VesuproObject) error {
+    w.encoder.targets[target] = vesupro.UnwrapObject(obj)
+    return w.ResultWriter.WriteResult(target, obj)
+}
@@ -0,0 +1,170 @@
+// Package session serves vesupro programs over long-lived connections.
+// Programs of a connection are evaluated in order and the targets they define
+// stay in scope for later programs of the same connection.
+//
+// Messages are JSON values, one request or response each. Any framed
+// connection implementing io.ReadWriter can be served, e.g. a net.Conn or a
+// WebSocket connection adapted to a net.Conn.
+package session
+
+import (
+    ".."
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "strings"
+    "sync"
+)
+
+// Request is a message of the client. It either carries a program or the ID
+// of a request to cancel.
+type Request struct {
+    ID string `json:"id"`
+    Program string `json:"program,omitempty"`
+    Cancel string `json:"cancel,omitempty"`
+}
+
+// Response answers a program request. Result holds the JSON object of the
+// program's targets if the program succeeded.
+type Response struct {
+    ID string `json:"id"`
+    Result json.RawMessage `json:"result,omitempty"`
+    Error string `json:"error,omitempty"`
+}
+
+// Server serves sessions on top of an evaluator. The evaluator's symbol
+// table is the initial scope of every session, see Scope.
+type Server struct {
+    Evaluator *vesupro.Evaluator
+    // MaxTargets bounds the targets kept per session, DefaultMaxTargets if
+    // it is 0.
+    MaxTargets int
+}
+
+// NewServer creates a server evaluating programs against symTable.
+func NewServer(symTable map[string]vesupro.VesuproObject) *Server {
+    return &Server{Evaluator: vesupro.NewEvaluator(symTable)}
+}
+
+// session is the state of a single connection.
+type session struct {
+    scope *Scope
+
+    mutex sync.Mutex
+    encoder *json.Encoder
+    inFlight map[string]context.CancelFunc
+}
+
+// Serve reads requests from rw until it is closed or a request cannot be
+// decoded, and writes the responses to rw. It returns once all programs are
+// done; programs are cancelled if decoding fails. Responses to cancelled
+// requests carry the error of their context. ctx is the parent of the
+// contexts of all programs; it may carry the identity of the client.
+func (s *Server) Serve(ctx context.Context, rw io.ReadWriter) error {
+    sess := &session{
+        scope: NewScope(s.Evaluator, s.MaxTargets),
+        encoder: json.NewEncoder(rw),
+        inFlight: make(map[string]context.CancelFunc),
+    }
+
+    ctx, cancel := context.WithCancel(ctx)
+    defer cancel()
+    decoder := json.NewDecoder(rw)
+    prev := make(chan struct{})
+    close(prev)
+    for {
+        req := &Request{}
+        err := decoder.Decode(req)
+        if err != nil {
+            // let pending programs finish, unless the connection broke
+            if err == io.EOF {
+                err = nil
+            } else {
+                cancel()
+            }
+            <-prev
+            return err
+        }
+
+        if req.Cancel != "" {
+            sess.cancel(req.Cancel)
+            continue
+        }
+
+        reqCtx, err := sess.start(ctx, req.ID)
+        if err != nil {
+            sess.respond(&Response{ID: req.ID, Error: err.Error()})
+            continue
+        }
+        // programs run one after another in the order of their requests
+        done := make(chan struct{})
+        go func(prev chan struct{}, req *Request) {
+            defer close(done)
+            <-prev
+            sess.respond(sess.run(reqCtx, req))
+            sess.finish(req.ID)
+        }(prev, req)
+        prev = done
+    }
+}
+
+// start registers the in-flight request id.
+func (s *session) start(ctx context.Context,
+id string) (context.Context, error) {
+    s.mutex.Lock()
+    defer s.mutex.Unlock()
+
+    if _, found := s.inFlight[id]; found {
+        return nil, fmt.Errorf("Request %s is in flight.", id)
+    }
+    ctx, cancel := context.WithCancel(ctx)
+    s.inFlight[id] = cancel
+    return ctx, nil
+}
+
+func (s *session) finish(id string) {
+    s.mutex.Lock()
+    defer s.mutex.Unlock()
+    if cancel, found := s.inFlight[id]; found {
+        cancel()
+        delete(s.inFlight, id)
+    }
+}
+
+func (s *session) cancel(id string) {
+    s.mutex.Lock()
+    defer s.mutex.Unlock()
+    if cancel, found := s.inFlight[id]; found {
+        cancel()
+    }
+}
+
+func (s *session) respond(resp *Response) {
+    s.mutex.Lock()
+    defer s.mutex.Unlock()
+    s.encoder.Encode(resp)
+}
+
+// run evaluates the program of req. The targets are added to the scope only
+// if the whole program succeeds.
+func (s *session) run(ctx context.Context, req *Request) *Response {
+    resp := &Response{ID: req.ID}
+    if err := ctx.Err(); err != nil {
+        resp.Error = err.Error()
+        return resp
+    }
+
+    out := &bytes.Buffer{}
+    defs, err := s.scope.evaluator.Parse(strings.NewReader(req.Program))
+    if err == nil {
+        err = s.scope.Evaluate(ctx, out, defs)
+    }
+    if err != nil {
+        resp.Error = err.Error()
+        return resp
+    }
+    resp.Result = out.Bytes()
+    return resp
+}
@@ -0,0 +1,202 @@
+package session_test
+
+import (
+    "./"
+    ".."
+    "testing"
+    "context"
+    "encoding/json"
+    "fmt"
+    "net"
+    "reflect"
+)
+
+type Users struct{}
+
+type User struct {
+    ID string
+}
+
+func (u *Users) Dispatch(mc *vesupro.MethodCall) (vesupro.VesuproObject, error) {
+    id, err := mc.Arguments[0].ToInt64()
+    if err != nil { return nil, err }
+    return &User{ID: fmt.Sprint(id)}, nil
+}
+
+func (u *Users) MarshalJSON() ([]byte, error) {
+    return []byte(`"users"`), nil
+}
+
+func (u *User) Dispatch(mc *vesupro.MethodCall) (vesupro.VesuproObject, error) {
+    return &User{ID: u.ID + "." + mc.Name}, nil
+}
+
+func (u *User) MarshalJSON() ([]byte, error) {
+    return json.Marshal(u.ID)
+}
+
+// blocking blocks every dispatch until it is released.
+type blocking chan struct{}
+
+func (b blocking) Dispatch(mc *vesupro.MethodCall) (vesupro.VesuproObject, error) {
+    <-b
+    return b, nil
+}
+
+func (b blocking) MarshalJSON() ([]byte, error) {
+    return []byte(`"released"`), nil
+}
+
+// serve starts a session on a pipe and returns the client's end.
+func serve(slow blocking) (*json.Encoder, *json.Decoder,
+chan error) {
+    return serveWith(session.NewServer(map[string]vesupro.VesuproObject{
+        "users": &Users{}, "slow": slow,
+    }))
+}
+
+func serveWith(server *session.Server) (*json.Encoder, *json.Decoder,
+chan error) {
+    client, conn := net.Pipe()
+    done := make(chan error, 1)
+    go func() {
+        done <- server.Serve(context.Background(), conn)
+        conn.Close()
+    }()
+    return json.NewEncoder(client), json.NewDecoder(client), done
+}
+
+// exchange sends the requests one after another and compares the
+// responses.
+func exchange(t *testing.T, enc *json.Encoder, dec *json.Decoder,
+requests []*session.Request, expected []*session.Response) {
+    for i, req := range requests {
+        if err := enc.Encode(req); err != nil {
+            t.Fatalf("%d. error: %q", i, err)
+        }
+        resp := &session.Response{}
+        if err := dec.Decode(resp); err != nil {
+            t.Fatalf("%d. error: %q", i, err)
+        }
+        if !reflect.DeepEqual(expected[i], resp) {
+            t.Errorf("%d. response mismatch %+v != %+v.", i, expected[i],
+            resp)
+        }
+    }
+}
+
+func TestServer_Scope(t *testing.T) {
+    enc, dec, _ := serve(make(blocking))
+
+    requests := []*session.Request{
+        {ID: "1", Program: `u := users.get(1);`},
+        {ID: "2", Program: `v := u.friend(); w := u.friend().friend();`},
+        {ID: "3", Program: `x := w.x(); users := u.y();`},
+        {ID: "4", Program: `y := x.y();`},
+        {ID: "5", Program: `u := u.z(); `},
+        {ID: "6", Program: `v := u.v();`},
+    }
+    expected := []*session.Response{
+        {ID: "1", Result: json.RawMessage(`{"u":"1"}`)},
+        {ID: "2", Result: json.RawMessage(
+            `{"v":"1.friend","w":"1.friend.friend"}`)},
+        {ID: "3", Error: "Target users shadows a receiver."},
+        // failed programs do not define targets
+        {ID: "4", Error: "Receiver not found x."},
+        {ID: "5", Result: json.RawMessage(`{"u":"1.z"}`)},
+        {ID: "6", Result: json.RawMessage(`{"v":"1.z.v"}`)},
+    }
+    exchange(t, enc, dec, requests, expected)
+}
+
+func TestServer_Origins(t *testing.T) {
+    server := session.NewServer(map[string]vesupro.VesuproObject{
+        "users": &Users{}, "admin": &Users{},
+    })
+    server.MaxTargets = 2
+    // targets are authorized and observed as the receiver they derive from
+    server.Evaluator.Authorizer = vesupro.AuthorizerFunc(
+    func(ctx context.Context, identity interface{}, receiver string,
+    call *vesupro.MethodCall) error {
+        if receiver == "admin" && call.Name == "delete" {
+            return fmt.Errorf("admins cannot be deleted")
+        }
+        return nil
+    })
+    var receivers []string
+    server.Evaluator.Interceptors = []vesupro.Interceptor{
+        func(ctx context.Context, call *vesupro.Call,
+        next vesupro.Invoker) (vesupro.VesuproObject, error) {
+            if call.Kind == vesupro.DispatchCall {
+                receivers = append(receivers, call.Receiver)
+            }
+            return next(ctx, call)
+        },
+    }
+    enc, dec, _ := serveWith(server)
+
+    requests := []*session.Request{
+        {ID: "1", Program: `a := admin.get(1); u := users.get(2);`},
+        {ID: "2", Program: `b := a.friend();`},
+        {ID: "3", Program: `a := a.friend();`},
+        {ID: "4", Program: `a := a.delete();`},
+        {ID: "5", Program: `u := u.delete();`},
+    }
+    expected := []*session.Response{
+        {ID: "1", Result: json.RawMessage(`{"a":"1","u":"2"}`)},
+        {ID: "2", Error: "Scope is limited to 2 targets."},
+        // replacing a target does not grow the scope
+        {ID: "3", Result: json.RawMessage(`{"a":"1.friend"}`)},
+        {ID: "4", Error: "Calling delete on admin is not authorized: " +
+            "admins cannot be deleted"},
+        {ID: "5", Result: json.RawMessage(`{"u":"2.delete"}`)},
+    }
+    exchange(t, enc, dec, requests, expected)
+
+    exp := "[admin users admin users]"
+    if fmt.Sprint(receivers) != exp {
+        t.Errorf("receivers mismatch %s != %v.", exp, receivers)
+    }
+}
+
+func TestServer_Cancel(t *testing.T) {
+    slow := make(blocking)
+    enc, dec, done := serve(slow)
+
+    // writes to a pipe return once the session has read the request, so the
+    // cancellation is seen before the third request is read
+    requests := []*session.Request{
+        {ID: "1", Program: `a := slow.wait().wait();`},
+        {ID: "2", Program: `b := users.get(2);`},
+        {Cancel: "1"},
+        {ID: "3", Program: `c := users.get(3);`},
+    }
+    for i, req := range requests {
+        if err := enc.Encode(req); err != nil {
+            t.Fatalf("%d. error: %q", i, err)
+        }
+    }
+    close(slow)
+
+    expected := []*session.Response{
+        {ID: "1", Error: context.Canceled.Error()},
+        {ID: "2", Result: json.RawMessage(`{"b":"2"}`)},
+        {ID: "3", Result: json.RawMessage(`{"c":"3"}`)},
+    }
+    for i, exp := range expected {
+        resp := &session.Response{}
+        if err := dec.Decode(resp); err != nil {
+            t.Fatalf("%d. error: %q", i, err)
+        }
+        if !reflect.DeepEqual(exp, resp) {
+            t.Errorf("%d. response mismatch %+v != %+v.", i, exp, resp)
+        }
+    }
+
+    if err := enc.Encode(json.RawMessage(`[]`)); err != nil {
+        t.Fatalf("error: %q", err)
+    }
+    if err := <-done; err == nil {
+        t.Errorf("expected decoding error.")
+    }
+}
@@ -28,4 +28,6 @@ const (
     NULL  // null
 
     JSON  // fast scan JSON
+    COLON // : of a named argument
+    VAR   // $name, a variable of a prepared query
 )
@@ -0,0 +1,77 @@
+package vesupro
+
+import (
+    "context"
+)
+
+// Span names and attribute keys used by the evaluator.
+const (
+    ProgramSpan = "vesupro.program"
+    DefinitionSpan = "vesupro.definition"
+    DispatchSpan = "vesupro.dispatch"
+    MarshalSpan = "vesupro.marshal"
+    BatchDispatchSpan = "vesupro.batch_dispatch"
+
+    DefinitionsAttribute = "vesupro.definitions"
+    TargetAttribute = "vesupro.target"
+    ReceiverAttribute = "vesupro.receiver"
+    MethodAttribute = "vesupro.method"
+    ArgumentsAttribute = "vesupro.arguments"
+    BatchSizeAttribute = "vesupro.batch_size"
+)
+
+// Attribute is a key-value pair attached to a span. Values are strings,
+// ints or bools.
+type Attribute struct {
+    Key string
+    Value interface{}
+}
+
+// Tracer starts spans. The evaluator emits a span for the program, one per
+// definition below it and one per dispatched method call and per marshalled
+// result below the definition. Batched calls are traced in a span below the
+// program.
+type Tracer interface {
+    // StartSpan starts a span as child of the span in ctx, if any, and
+    // returns a context carrying the new span.
+    StartSpan(ctx context.Context, name string,
+        attrs ...Attribute) (context.Context, Span)
+}
+
+// Span is a single traced operation.
+type Span interface {
+    SetAttributes(attrs ...Attribute)
+    // End finishes the span, recording err if it is not nil.
+    End(err error)
+}
+
+// tracingInterceptor emits a span for every Dispatch, BatchDispatch and
+// MarshalJSON. It is the innermost interceptor, so that retries of other
+// interceptors show up as separate spans.
+func tracingInterceptor(tracer Tracer) Interceptor {
+    return func(ctx context.Context, call *Call,
+    next Invoker) (VesuproObject, error) {
+        name := MarshalSpan
+        attrs := []Attribute{
+            {TargetAttribute, call.Target},
+            {ReceiverAttribute, call.Receiver},
+        }
+        switch call.Kind {
+        case DispatchCall:
+            name = DispatchSpan
+            attrs = append(attrs,
+                Attribute{MethodAttribute, call.MethodCall.Name},
+                Attribute{ArgumentsAttribute, len(call.MethodCall.Arguments)})
+        case BatchDispatchCall:
+            name = BatchDispatchSpan
+            attrs = append(attrs[1:],
+                Attribute{MethodAttribute, call.Batch[0].Name},
+                Attribute{BatchSizeAttribute, len(call.Batch)})
+        }
+
+        ctx, span := tracer.StartSpan(ctx, name, attrs...)
+        obj, err := next(ctx, call)
+        span.End(err)
+        return obj, err
+    }
+}
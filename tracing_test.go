@@ -0,0 +1,83 @@
+package vesupro_test
+
+import (
+    "./"
+    "testing"
+    "bytes"
+    "context"
+    "fmt"
+    "reflect"
+    "strings"
+)
+
+// memoryTracer records finished spans as "path {attributes} error".
+type memoryTracer struct {
+    spans []string
+}
+
+type memorySpan struct {
+    tracer *memoryTracer
+    path string
+    attrs []string
+}
+
+type spanKey struct{}
+
+func (m *memoryTracer) StartSpan(ctx context.Context, name string,
+attrs ...vesupro.Attribute) (context.Context, vesupro.Span) {
+    path := name
+    if parent, ok := ctx.Value(spanKey{}).(*memorySpan); ok {
+        path = parent.path + " > " + name
+    }
+    span := &memorySpan{tracer: m, path: path}
+    span.SetAttributes(attrs...)
+    return context.WithValue(ctx, spanKey{}, span), span
+}
+
+func (s *memorySpan) SetAttributes(attrs ...vesupro.Attribute) {
+    for _, attr := range attrs {
+        s.attrs = append(s.attrs, fmt.Sprintf("%s=%v",
+            strings.TrimPrefix(attr.Key, "vesupro."), attr.Value))
+    }
+}
+
+func (s *memorySpan) End(err error) {
+    out := s.path + " {" + strings.Join(s.attrs, " ") + "}"
+    if err != nil {
+        out += " " + err.Error()
+    }
+    s.tracer.spans = append(s.tracer.spans, out)
+}
+
+func TestEvaluator_Tracer(t *testing.T) {
+    tracer := &memoryTracer{}
+    ev := vesupro.NewEvaluator(map[string]vesupro.VesuproObject{
+        "obj": &countingObject{}, "flaky": &flakyObject{},
+    })
+    ev.Tracer = tracer
+
+    err := ev.Evaluate(&bytes.Buffer{},
+        bytes.NewBufferString(`v1 := obj.a(1, 2).b(); v2 := flaky.c();`))
+    if err == nil {
+        t.Fatalf("expected error.")
+    }
+
+    exp := []string{
+        "vesupro.program > vesupro.definition > vesupro.dispatch " +
+            "{target=v1 receiver=obj method=a arguments=2}",
+        "vesupro.program > vesupro.definition > vesupro.dispatch " +
+            "{target=v1 receiver=obj method=b arguments=0}",
+        "vesupro.program > vesupro.definition > vesupro.marshal " +
+            "{target=v1 receiver=obj}",
+        "vesupro.program > vesupro.definition {target=v1 receiver=obj}",
+        "vesupro.program > vesupro.definition > vesupro.dispatch " +
+            "{target=v2 receiver=flaky method=c arguments=0} flaky.c: flaky",
+        "vesupro.program > vesupro.definition {target=v2 receiver=flaky} " +
+            "flaky.c: flaky",
+        "vesupro.program {definitions=2} flaky.c: flaky",
+    }
+    if !reflect.DeepEqual(exp, tracer.spans) {
+        t.Errorf("spans mismatch\n%s\n!=\n%s.", strings.Join(exp, "\n"),
+        strings.Join(tracer.spans, "\n"))
+    }
+}